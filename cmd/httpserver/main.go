@@ -1,18 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/trial-pyth/httpfromtcp/internal/accesslog"
+	"github.com/trial-pyth/httpfromtcp/internal/headers"
 	"github.com/trial-pyth/httpfromtcp/internal/request"
 	"github.com/trial-pyth/httpfromtcp/internal/response"
+	"github.com/trial-pyth/httpfromtcp/internal/router"
 	"github.com/trial-pyth/httpfromtcp/internal/server"
 )
 
@@ -68,90 +73,119 @@ func respond200() []byte {
 	`)
 }
 
-func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-
-		h := response.GetDefaultHeaders(0)
-		body := respond200()
-		status := response.StatusOK
-
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			body = respond400()
-			status = response.StatusBadRequest
-		} else if req.RequestLine.RequestTarget == "/myproblem" {
-			body = respond500()
-			status = response.StatusInternalServerError
-		} else if req.RequestLine.RequestTarget == "/video" {
-			f, _ := os.ReadFile("assets/vim.mp4")
-			h.Replace("Content-Type", "video/mp4")
-			h.Replace("Content-Length", fmt.Sprintf("%d", len(f)))
-			w.WriteStatusLine(response.StatusOK)
-			w.WriteHeaders(*h)
-			w.WriteBody(f)
-		} else if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
-
-			target := req.RequestLine.RequestTarget
-			res, err := http.Get("https://httpbin.org/" + target[len("/httpbin/"):])
-			if err != nil {
-				body = respond500()
-				status = response.StatusInternalServerError
-			} else {
-				defer res.Body.Close()
-				w.WriteStatusLine(response.StatusOK)
-				h.Delete("Content-Length")
-				h.Set("Transfer-Encoding", "chunked")
-				h.Set("Trailer", "X-Content-Sha256")
-				h.Set("Trailer", "X-Content-Length")
-				h.Replace("Content-Type", "text/plain")
-				w.WriteHeaders(*h)
-
-				// Write chunked data from httpbin response
-				fullBody := []byte{}
-
-				for {
-					data := make([]byte, 32)
-					n, err := res.Body.Read(data)
-					if err != nil {
-						if err == io.EOF {
-							break
-						}
-						break
-					}
-					if n == 0 {
-						break
-					}
-
-					fullBody = append(fullBody, data[:n]...)
-					// Write chunk: <length in hex>\r\n<data>\r\n
-					w.WriteBody([]byte(fmt.Sprintf("%x\r\n", n)))
-					w.WriteBody(data[:n])
-					w.WriteBody([]byte("\r\n"))
-				}
-				// Write final chunk marker: 0\r\n
-				w.WriteBody([]byte("0\r\n"))
-				// Write trailers (must come after 0\r\n but before final \r\n)
-				out := sha256.Sum256(fullBody)
-				w.WriteBody([]byte(fmt.Sprintf("X-Content-Sha256: %s\r\nX-Content-Length: %d\r\n\r\n", toStr(out[:]), len(fullBody))))
-				return
-			}
+func writeHTML(w *response.Writer, status response.StatusCode, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	w.WriteStatusLine(status)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
 
+func handleVideo(w *response.Writer, req *request.Request) {
+	f, _ := os.ReadFile("assets/vim.mp4")
+	h := response.GetDefaultHeaders(len(f))
+	h.Set("Content-Type", "video/mp4")
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(f)
+}
+
+func handleHttpbin(w *response.Writer, req *request.Request) {
+	// The route also matches a bare /httpbin. Trimming keeps the raw path and
+	// query string so they reach httpbin as sent.
+	target := strings.TrimPrefix(req.RequestLine.RequestTarget, "/httpbin")
+	target = strings.TrimPrefix(target, "/")
+	res, err := http.Get("https://httpbin.org/" + target)
+	if err != nil {
+		writeHTML(w, response.StatusInternalServerError, respond500())
+		return
+	}
+	defer res.Body.Close()
+
+	h := response.GetDefaultHeaders(0)
+	w.WriteStatusLine(response.StatusOK)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Add("Trailer", "X-Content-Sha256")
+	h.Add("Trailer", "X-Content-Length")
+	h.Set("Content-Type", "text/plain")
+	w.WriteHeaders(*h)
+
+	// Stream the httpbin response back in chunks
+	fullBody := []byte{}
+
+	for {
+		data := make([]byte, 32)
+		n, err := res.Body.Read(data)
+		if n > 0 {
+			fullBody = append(fullBody, data[:n]...)
+			w.WriteChunkedBody(data[:n])
+		}
+		if err != nil {
+			break
 		}
+	}
+	w.WriteChunkedBodyDone()
 
-		h.Replace("Content-Length", fmt.Sprintf("%d", len(body)))
-		h.Replace("Content-Type", "text/plain")
-		w.WriteStatusLine(status)
-		w.WriteHeaders(*h)
-		w.WriteBody(body)
+	out := sha256.Sum256(fullBody)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Content-Sha256", toStr(out[:]))
+	trailers.Set("X-Content-Length", fmt.Sprintf("%d", len(fullBody)))
+	w.WriteTrailers(*trailers)
+}
 
+func main() {
+	rt := router.New()
+	rt.Handle("GET", "/yourproblem", func(w *response.Writer, req *request.Request) {
+		writeHTML(w, response.StatusBadRequest, respond400())
 	})
+	rt.Handle("GET", "/myproblem", func(w *response.Writer, req *request.Request) {
+		writeHTML(w, response.StatusInternalServerError, respond500())
+	})
+	rt.Handle("GET", "/video", handleVideo)
+	rt.Handle("GET", "/httpbin/*", handleHttpbin)
+	rt.Handle("GET", "/*", func(w *response.Writer, req *request.Request) {
+		writeHTML(w, response.StatusOK, respond200())
+	})
+
+	config := server.DefaultConfig
+	config.Addr = fmt.Sprintf(":%d", port)
+	config.Handler = rt.Handler()
+	config.Middleware = []server.Middleware{
+		accesslog.Middleware(accesslog.NewLogger(os.Stdout, accesslog.FormatCombined)),
+	}
+	srv := server.New(config)
+
+	// Under systemd socket activation the sockets come from the socket
+	// unit, otherwise listen on the default port
+	listeners, err := server.ActivationListeners()
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	if len(listeners) == 0 {
+		go func() {
+			if err := srv.ListenAndServe(); !errors.Is(err, server.ErrServerClosed) {
+				log.Fatalf("Error starting server: %v", err)
+			}
+		}()
+		log.Println("Server started on port", port)
+	}
+	for _, listener := range listeners {
+		go func() {
+			if err := srv.Serve(listener); !errors.Is(err, server.ErrServerClosed) {
+				log.Fatalf("Error serving %s: %v", listener.Addr(), err)
+			}
+		}()
+		log.Println("Server started on", listener.Addr())
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down: %v", err)
+	}
 	log.Println("Server gracefully stopped")
 }
@@ -57,8 +57,14 @@ func handleConnection(conn net.Conn) {
 	r.Headers.ForEach(func(k, v string) {
 		fmt.Printf("- %s: %s\n", k, v)
 	})
+	body, err := r.ReadBody()
+	if err != nil {
+		log.Printf("Error reading body from %s: %v\n", conn.RemoteAddr(), err)
+		return
+	}
+
 	fmt.Printf("Body:\n")
-	fmt.Printf("%s\n", r.Body)
+	fmt.Printf("%s\n", body)
 	fmt.Println("---")
 
 	// Send HTTP response
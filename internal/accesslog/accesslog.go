@@ -0,0 +1,174 @@
+// Package accesslog records the requests a server answers through log/slog.
+package accesslog
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+	"github.com/trial-pyth/httpfromtcp/internal/server"
+)
+
+// Attribute keys of an access log record
+const (
+	KeyRemoteAddr = "remote_addr"
+	KeyMethod     = "method"
+	KeyTarget     = "target"
+	KeyProto      = "proto"
+	KeyStatus     = "status"
+	KeyBytes      = "bytes"
+	KeyDuration   = "duration"
+	KeyUserAgent  = "user_agent"
+	KeyReferer    = "referer"
+)
+
+// Middleware logs every request to logger at info level once it has been
+// answered. A handler that panics is still logged, with whatever status was
+// written before the panic or 500 if none was, the status the server's
+// recovery answers with.
+func Middleware(logger *slog.Logger) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			start := time.Now()
+			defer func() {
+				status := int(w.StatusCode())
+				p := recover()
+				if p != nil && status == 0 {
+					status = int(response.StatusInternalServerError)
+				}
+				logger.LogAttrs(context.Background(), slog.LevelInfo, "request",
+					slog.String(KeyRemoteAddr, remoteAddr(req)),
+					slog.String(KeyMethod, req.RequestLine.Method),
+					slog.String(KeyTarget, req.RequestLine.RequestTarget),
+					slog.String(KeyProto, "HTTP/"+req.RequestLine.HttpVersion),
+					slog.Int(KeyStatus, status),
+					slog.Int(KeyBytes, w.BytesWritten()),
+					slog.Duration(KeyDuration, time.Since(start)),
+					slog.String(KeyUserAgent, header(req, "user-agent")),
+					slog.String(KeyReferer, header(req, "referer")),
+				)
+				if p != nil {
+					panic(p)
+				}
+			}()
+			next(w, req)
+		}
+	}
+}
+
+func remoteAddr(req *request.Request) string {
+	if req.RemoteAddr == nil {
+		return ""
+	}
+	return req.RemoteAddr.String()
+}
+
+func header(req *request.Request, name string) string {
+	v, _ := req.Headers.Get(name)
+	return v
+}
+
+// Format selects how NewLogger writes records
+type Format int
+
+const (
+	// FormatJSON writes one JSON object per request
+	FormatJSON Format = iota
+	// FormatCommon writes the Common Log Format
+	FormatCommon
+	// FormatCombined writes the Combined Log Format, which adds the referer
+	// and user agent to the Common Log Format
+	FormatCombined
+)
+
+// NewLogger returns a logger writing access log records to out in format
+func NewLogger(out io.Writer, format Format) *slog.Logger {
+	switch format {
+	case FormatCommon, FormatCombined:
+		return slog.New(&clfHandler{out: out, combined: format == FormatCombined, mu: &sync.Mutex{}})
+	default:
+		return slog.New(slog.NewJSONHandler(out, nil))
+	}
+}
+
+// clfHandler formats access log records as Common or Combined Log Format
+// lines. Records without the access log attributes are written with "-" for
+// the missing fields.
+type clfHandler struct {
+	out      io.Writer
+	combined bool
+	attrs    []slog.Attr
+	// mu is shared with the handlers derived through WithAttrs
+	mu *sync.Mutex
+}
+
+func (h *clfHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *clfHandler) Handle(_ context.Context, record slog.Record) error {
+	fields := map[string]slog.Value{}
+	for _, a := range h.attrs {
+		fields[a.Key] = a.Value
+	}
+	record.Attrs(func(a slog.Attr) bool {
+		fields[a.Key] = a.Value
+		return true
+	})
+	field := func(key string) string {
+		if v, ok := fields[key]; ok && v.String() != "" {
+			return v.String()
+		}
+		return "-"
+	}
+
+	host := field(KeyRemoteAddr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	requestLine := strings.Join([]string{field(KeyMethod), field(KeyTarget), field(KeyProto)}, " ")
+	size := field(KeyBytes)
+	if size == "0" {
+		size = "-"
+	}
+
+	b := []byte(host)
+	b = append(b, " - - ["...)
+	b = record.Time.AppendFormat(b, "02/Jan/2006:15:04:05 -0700")
+	b = append(b, "] "...)
+	b = strconv.AppendQuote(b, requestLine)
+	b = append(b, ' ')
+	b = append(b, field(KeyStatus)...)
+	b = append(b, ' ')
+	b = append(b, size...)
+	if h.combined {
+		b = append(b, ' ')
+		b = strconv.AppendQuote(b, field(KeyReferer))
+		b = append(b, ' ')
+		b = strconv.AppendQuote(b, field(KeyUserAgent))
+	}
+	b = append(b, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(b)
+	return err
+}
+
+func (h *clfHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(clone.attrs[:len(clone.attrs):len(clone.attrs)], attrs...)
+	return &clone
+}
+
+// WithGroup is a no-op, the log format has no room for nested fields
+func (h *clfHandler) WithGroup(string) slog.Handler {
+	return h
+}
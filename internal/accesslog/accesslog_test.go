@@ -0,0 +1,92 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+	"github.com/trial-pyth/httpfromtcp/internal/server"
+)
+
+func hello(w *response.Writer, req *request.Request) {
+	body := []byte("hello")
+	w.WriteStatusLine(response.StatusCreated)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// serve runs h behind the access log middleware for a request with extra
+// headers and returns what was logged
+func serve(t *testing.T, format Format, h server.Handler, extra string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET /greet?name=x HTTP/1.1\r\nHost: localhost\r\n" + extra + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5555}
+
+	out := &bytes.Buffer{}
+	Middleware(NewLogger(out, format))(h)(response.NewWriter(&bytes.Buffer{}), req)
+	return out.String()
+}
+
+func TestFormats(t *testing.T) {
+	extra := "User-Agent: curl/8.0\r\nReferer: http://example.com/\r\n"
+
+	// Test: JSON
+	var record map[string]any
+	require.NoError(t, json.Unmarshal([]byte(serve(t, FormatJSON, hello, extra)), &record))
+	assert.Equal(t, "request", record["msg"])
+	assert.Equal(t, "192.0.2.1:5555", record[KeyRemoteAddr])
+	assert.Equal(t, "GET", record[KeyMethod])
+	assert.Equal(t, "/greet?name=x", record[KeyTarget])
+	assert.Equal(t, "HTTP/1.1", record[KeyProto])
+	assert.Equal(t, float64(201), record[KeyStatus])
+	assert.Equal(t, float64(5), record[KeyBytes])
+	assert.Equal(t, "curl/8.0", record[KeyUserAgent])
+	assert.Equal(t, "http://example.com/", record[KeyReferer])
+	assert.Contains(t, record, KeyDuration)
+
+	// Test: Common Log Format
+	date := `\[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\]`
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - `+date+` "GET /greet\?name=x HTTP/1\.1" 201 5\n$`),
+		serve(t, FormatCommon, hello, extra))
+
+	// Test: Combined Log Format
+	assert.Regexp(t, regexp.MustCompile(`^192\.0\.2\.1 - - `+date+` "GET /greet\?name=x HTTP/1\.1" 201 5 "http://example.com/" "curl/8\.0"\n$`),
+		serve(t, FormatCombined, hello, extra))
+
+	// Test: Missing fields
+	empty := func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusNoContent)
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+	assert.Regexp(t, regexp.MustCompile(` 204 - "-" "-"\n$`), serve(t, FormatCombined, empty, ""))
+}
+
+func TestPanickingHandler(t *testing.T) {
+	// Test: A request whose handler panics is logged before the panic goes on
+	out := &bytes.Buffer{}
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	h := Middleware(NewLogger(out, FormatCommon))(func(w *response.Writer, req *request.Request) {
+		panic("boom")
+	})
+
+	assert.PanicsWithValue(t, "boom", func() { h(response.NewWriter(&bytes.Buffer{}), req) })
+	assert.Contains(t, out.String(), `"GET / HTTP/1.1" 500 -`)
+
+	// Test: A status written before the panic is kept
+	out.Reset()
+	h = Middleware(NewLogger(out, FormatCommon))(func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusAccepted)
+		panic("boom")
+	})
+	assert.Panics(t, func() { h(response.NewWriter(&bytes.Buffer{}), req) })
+	assert.Contains(t, out.String(), `"GET / HTTP/1.1" 202 -`)
+}
@@ -9,8 +9,16 @@ import (
 
 var rn = []byte("\r\n")
 
+// Headers is an ordered list of header fields. Names keep the casing they
+// were given and are matched case-insensitively, so fields are written back
+// in the order and spelling they were added.
 type Headers struct {
-	headers map[string]string
+	fields []field
+}
+
+type field struct {
+	name  string
+	value string
 }
 
 var tokenChars = []byte{'!', '#', '$', '%', '&', '\'', '*', '+', '-', '.', '^', '_', '`', '|', '~'}
@@ -29,56 +37,91 @@ func isValidToken(data []byte) bool {
 }
 
 func NewHeaders() *Headers {
-	return &Headers{
-		headers: map[string]string{},
-	}
+	return &Headers{}
 }
 
+// Get returns the values of every field named name joined with ", ", which
+// is the combined field value for list-based fields. Fields that can't be
+// combined, like Set-Cookie, have to be read with Values.
 func (h *Headers) Get(name string) (string, bool) {
-	str, ok := h.headers[strings.ToLower(name)]
-	return str, ok
+	values := h.Values(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return strings.Join(values, ", "), true
 }
 
-func (h *Headers) Replace(key, value string) {
-	key = strings.ToLower(key)
-	h.headers[key] = value
+// Values returns the values of every field named name in order
+func (h *Headers) Values(name string) []string {
+	var values []string
+	for _, f := range h.fields {
+		if strings.EqualFold(f.name, name) {
+			values = append(values, f.value)
+		}
+	}
+	return values
 }
 
-func (h *Headers) Delete(name string) {
-	name = strings.ToLower(name)
-	delete(h.headers, name)
+// Add appends a field, keeping any fields with the same name
+func (h *Headers) Add(name, value string) {
+	h.fields = append(h.fields, field{name: name, value: value})
 }
 
-func (h *Headers) Set(key, value string) {
-	key = strings.ToLower(key)
-	if existingValue, ok := h.headers[key]; ok {
-		h.headers[key] = existingValue + ", " + value
-	} else {
-		h.headers[key] = value
+// Set replaces every field named name with a single field holding value. It
+// takes the place of the first of them, or is appended if there is none.
+func (h *Headers) Set(name, value string) {
+	named := func(f field) bool { return strings.EqualFold(f.name, name) }
+	i := slices.IndexFunc(h.fields, named)
+	if i == -1 {
+		h.Add(name, value)
+		return
 	}
+	h.fields[i] = field{name: name, value: value}
+	rest := slices.DeleteFunc(h.fields[i+1:], named)
+	h.fields = h.fields[:i+1+len(rest)]
 }
 
+// Delete removes every field named name
+func (h *Headers) Delete(name string) {
+	h.fields = slices.DeleteFunc(h.fields, func(f field) bool { return strings.EqualFold(f.name, name) })
+}
+
+// ForEach calls cb for every field in order, with the name as it was added
 func (h *Headers) ForEach(cb func(k, v string)) {
-	for k, v := range h.headers {
-		cb(k, v)
+	for _, f := range h.fields {
+		cb(f.name, f.value)
 	}
 }
 
+// Len returns the number of fields, counting repeated names separately
 func (h *Headers) Len() int {
-	return len(h.headers)
+	return len(h.fields)
 }
 
+var ErrorMalformedFieldLine = fmt.Errorf("malformed field line")
+var ErrorInvalidFieldName = fmt.Errorf("invalid field name")
+var ErrorInvalidFieldValue = fmt.Errorf("invalid field value")
+var ErrorObsFold = fmt.Errorf("obsolete line folding not allowed")
+
+// parseHeader splits a field line into its name and value. RFC 9112 is
+// strict here: no whitespace around the name and only visible characters,
+// spaces and tabs in the value.
 func parseHeader(fieldLine []byte) (string, string, error) {
-	parts := bytes.SplitN(fieldLine, []byte(":"), 2)
-	if len(parts) != 2 {
-		return "", "", fmt.Errorf("malformed field line")
+	name, value, found := bytes.Cut(fieldLine, []byte(":"))
+	if !found {
+		return "", "", ErrorMalformedFieldLine
+	}
 
+	if len(name) == 0 || !isValidToken(name) {
+		return "", "", fmt.Errorf("%w: %q", ErrorInvalidFieldName, name)
 	}
-	name := parts[0]
-	value := bytes.TrimSpace(parts[1])
 
-	if bytes.HasSuffix(name, []byte(" ")) {
-		return "", "", fmt.Errorf("malformed field name")
+	value = bytes.Trim(value, " \t")
+	for _, c := range value {
+		// field-vchar = VCHAR / obs-text, with SP and HTAB in between
+		if c != ' ' && c != '\t' && (c < 0x21 || c == 0x7f) {
+			return "", "", fmt.Errorf("%w: %q in %s", ErrorInvalidFieldValue, c, name)
+		}
 	}
 
 	return string(name), string(value), nil
@@ -99,17 +142,24 @@ func (h *Headers) Parse(data []byte) (int, bool, error) {
 			break
 		}
 
-		name, value, err := parseHeader(data[read : read+idx])
-		if err != nil {
-			return 0, false, err
+		line := data[read : read+idx]
+		if line[0] == ' ' || line[0] == '\t' {
+			// A continuation of the previous field, or whitespace in front
+			// of the first one. Both have been used to smuggle fields past
+			// other parsers.
+			if h.Len() > 0 {
+				return 0, false, ErrorObsFold
+			}
+			return 0, false, fmt.Errorf("%w: leading whitespace", ErrorInvalidFieldName)
 		}
 
-		if !isValidToken([]byte(name)) {
-			return 0, false, fmt.Errorf("malformed header name")
+		name, value, err := parseHeader(line)
+		if err != nil {
+			return 0, false, err
 		}
 
 		read += idx + len(rn)
-		h.Set(name, value)
+		h.Add(name, value)
 	}
 
 	return read, done, nil
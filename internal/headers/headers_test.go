@@ -51,3 +51,102 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, 23, n)
 	assert.False(t, done)
 }
+
+func TestHeadersFields(t *testing.T) {
+	// Test: Parsed fields keep their order, casing and repeats
+	headers := NewHeaders()
+	_, _, err := headers.Parse([]byte("Host: localhost\r\nSet-Cookie: a=1\r\nAccept: */*\r\nset-cookie: b=2\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 4, headers.Len())
+	assert.Equal(t, []string{"a=1", "b=2"}, headers.Values("SET-COOKIE"))
+	assert.Nil(t, headers.Values("Missing"))
+	assert.Equal(t, []string{"Host: localhost", "Set-Cookie: a=1", "Accept: */*", "set-cookie: b=2"}, fieldLines(headers))
+
+	// Test: Add appends
+	headers.Add("Accept", "text/html")
+	accept, ok := headers.Get("accept")
+	assert.True(t, ok)
+	assert.Equal(t, "*/*, text/html", accept)
+
+	// Test: Set replaces every field with the name in place of the first
+	headers.Set("Set-Cookie", "c=3")
+	assert.Equal(t, []string{"Host: localhost", "Set-Cookie: c=3", "Accept: */*", "Accept: text/html"}, fieldLines(headers))
+	headers.Set("X-New", "1")
+	assert.Equal(t, "X-New: 1", fieldLines(headers)[4])
+
+	// Test: Delete removes every field with the name
+	headers.Delete("ACCEPT")
+	assert.Equal(t, []string{"Host: localhost", "Set-Cookie: c=3", "X-New: 1"}, fieldLines(headers))
+	_, ok = headers.Get("Accept")
+	assert.False(t, ok)
+}
+
+func TestConformance(t *testing.T) {
+	// RFC 9112 section 5: field lines a recipient must accept
+	valid := []struct {
+		name  string
+		data  string
+		value string
+	}{
+		{"plain", "X-Test: value\r\n\r\n", "value"},
+		{"optional whitespace", "X-Test: \t value \t\r\n\r\n", "value"},
+		{"no whitespace", "X-Test:value\r\n\r\n", "value"},
+		{"inner whitespace", "X-Test: a \tb\r\n\r\n", "a \tb"},
+		{"empty value", "X-Test:\r\n\r\n", ""},
+		{"obs-text", "X-Test: caf\xc3\xa9\r\n\r\n", "caf\xc3\xa9"},
+		{"token characters", "X-Test: !#$%&'*+-.^_`|~\"(),/:;<=>?@[\\]{}\r\n\r\n", "!#$%&'*+-.^_`|~\"(),/:;<=>?@[\\]{}"},
+	}
+	for _, c := range valid {
+		h := NewHeaders()
+		_, done, err := h.Parse([]byte(c.data))
+		require.NoError(t, err, c.name)
+		assert.True(t, done, c.name)
+		value, ok := h.Get("X-Test")
+		assert.True(t, ok, c.name)
+		assert.Equal(t, c.value, value, c.name)
+	}
+
+	// Field lines a recipient must reject
+	invalid := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"no colon", "X-Test value\r\n\r\n", ErrorMalformedFieldLine},
+		{"empty name", ": value\r\n\r\n", ErrorInvalidFieldName},
+		{"space before colon", "X-Test : value\r\n\r\n", ErrorInvalidFieldName},
+		{"tab before colon", "X-Test\t: value\r\n\r\n", ErrorInvalidFieldName},
+		{"space in name", "X Test: value\r\n\r\n", ErrorInvalidFieldName},
+		{"separator in name", "X@Test: value\r\n\r\n", ErrorInvalidFieldName},
+		{"leading whitespace", " X-Test: value\r\n\r\n", ErrorInvalidFieldName},
+		{"obs-fold with space", "X-Test: value\r\n more\r\n\r\n", ErrorObsFold},
+		{"obs-fold with tab", "X-Test: value\r\n\tmore\r\n\r\n", ErrorObsFold},
+		{"bare CR", "X-Test: val\rue\r\n\r\n", ErrorInvalidFieldValue},
+		{"bare LF", "X-Test: value\nX-Other: smuggled\r\n\r\n", ErrorInvalidFieldValue},
+		{"NUL", "X-Test: val\x00ue\r\n\r\n", ErrorInvalidFieldValue},
+		{"control character", "X-Test: val\x01ue\r\n\r\n", ErrorInvalidFieldValue},
+		{"DEL", "X-Test: val\x7fue\r\n\r\n", ErrorInvalidFieldValue},
+	}
+	for _, c := range invalid {
+		h := NewHeaders()
+		n, done, err := h.Parse([]byte(c.data))
+		assert.ErrorIs(t, err, c.err, c.name)
+		assert.Equal(t, 0, n, c.name)
+		assert.False(t, done, c.name)
+	}
+
+	// Test: A fold arriving in a later read is still caught
+	h := NewHeaders()
+	_, _, err := h.Parse([]byte("X-Test: value\r\n"))
+	require.NoError(t, err)
+	_, _, err = h.Parse([]byte(" more\r\n\r\n"))
+	assert.ErrorIs(t, err, ErrorObsFold)
+}
+
+func fieldLines(h *Headers) []string {
+	lines := []string{}
+	h.ForEach(func(k, v string) {
+		lines = append(lines, k+": "+v)
+	})
+	return lines
+}
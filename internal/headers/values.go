@@ -0,0 +1,228 @@
+package headers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrorMissingHeader = fmt.Errorf("header not present")
+var ErrorInvalidContentLength = fmt.Errorf("invalid Content-Length")
+var ErrorInvalidMediaType = fmt.Errorf("invalid media type")
+var ErrorInvalidParameter = fmt.Errorf("invalid parameter")
+var ErrorInvalidList = fmt.Errorf("invalid list")
+var ErrorInvalidDate = fmt.Errorf("invalid date")
+
+// TimeFormat is the IMF-fixdate format of RFC 9110, the one to send dates in
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// The obsolete date formats recipients still have to accept
+const (
+	rfc850Format  = "Monday, 02-Jan-06 15:04:05 GMT"
+	asctimeFormat = "Mon Jan _2 15:04:05 2006"
+)
+
+// ContentLength returns the value of the Content-Length header. Repeated
+// fields are only accepted if they all carry the same length.
+func (h *Headers) ContentLength() (int64, error) {
+	values := h.Values("Content-Length")
+	if len(values) == 0 {
+		return 0, fmt.Errorf("%w: Content-Length", ErrorMissingHeader)
+	}
+
+	length := int64(-1)
+	for _, value := range values {
+		// A single field may hold a list of lengths as well
+		elements, err := ParseList(value)
+		if err != nil || len(elements) == 0 {
+			return 0, fmt.Errorf("%w: %q", ErrorInvalidContentLength, value)
+		}
+		for _, element := range elements {
+			n, err := parseDigits(element)
+			if err != nil || (length != -1 && n != length) {
+				return 0, fmt.Errorf("%w: %q", ErrorInvalidContentLength, value)
+			}
+			length = n
+		}
+	}
+
+	return length, nil
+}
+
+// parseDigits parses a non-negative decimal number without sign or spaces
+func parseDigits(s string) (int64, error) {
+	if s == "" || strings.TrimLeft(s, "0123456789") != "" {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// ContentType returns the media type of the Content-Type header, lowercased,
+// along with its parameters.
+func (h *Headers) ContentType() (string, map[string]string, error) {
+	values := h.Values("Content-Type")
+	if len(values) == 0 {
+		return "", nil, fmt.Errorf("%w: Content-Type", ErrorMissingHeader)
+	}
+	if len(values) > 1 {
+		return "", nil, fmt.Errorf("%w: repeated Content-Type", ErrorInvalidMediaType)
+	}
+
+	return ParseMediaType(values[0])
+}
+
+// ParseMediaType parses a media type such as `text/html; charset="utf-8"`
+// into the lowercased type and its parameters.
+func ParseMediaType(value string) (string, map[string]string, error) {
+	mediaType, rest, _ := strings.Cut(value, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	typ, subtype, ok := strings.Cut(mediaType, "/")
+	if !ok || typ == "" || subtype == "" || !isValidToken([]byte(typ)) || !isValidToken([]byte(subtype)) {
+		return "", nil, fmt.Errorf("%w: %q", ErrorInvalidMediaType, value)
+	}
+
+	params, err := ParseParams(rest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mediaType, params, nil
+}
+
+// ParseParams parses parameters of the form `name=value; name="quoted"`.
+// Names are lowercased, a leading ";" is allowed and repeated names are an
+// error.
+func ParseParams(value string) (map[string]string, error) {
+	params := map[string]string{}
+	s := value
+	for {
+		s = strings.TrimLeft(s, " \t;")
+		if s == "" {
+			return params, nil
+		}
+
+		name, rest, ok := strings.Cut(s, "=")
+		name = strings.ToLower(name)
+		if !ok || name == "" || !isValidToken([]byte(name)) {
+			return nil, fmt.Errorf("%w: %q", ErrorInvalidParameter, value)
+		}
+		if _, ok := params[name]; ok {
+			return nil, fmt.Errorf("%w: repeated %q", ErrorInvalidParameter, name)
+		}
+
+		var paramValue string
+		if strings.HasPrefix(rest, `"`) {
+			n, unquoted, err := readQuoted(rest)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q", ErrorInvalidParameter, value)
+			}
+			paramValue, s = unquoted, rest[n:]
+		} else {
+			end := strings.IndexAny(rest, "; \t")
+			if end == -1 {
+				end = len(rest)
+			}
+			paramValue, s = rest[:end], rest[end:]
+			if paramValue == "" || !isValidToken([]byte(paramValue)) {
+				return nil, fmt.Errorf("%w: %q", ErrorInvalidParameter, value)
+			}
+		}
+
+		// Only whitespace may separate a value from the next ";"
+		s = strings.TrimLeft(s, " \t")
+		if s != "" && s[0] != ';' {
+			return nil, fmt.Errorf("%w: %q", ErrorInvalidParameter, value)
+		}
+		params[name] = paramValue
+	}
+}
+
+// readQuoted reads the quoted-string at the start of s. It returns how many
+// bytes it spans and its unescaped content.
+func readQuoted(s string) (int, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"':
+			return i + 1, b.String(), nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			b.WriteByte(s[i])
+		case c == '\t' || (c >= ' ' && c != 0x7f):
+			b.WriteByte(c)
+		default:
+			return 0, "", fmt.Errorf("invalid character %q in quoted string", c)
+		}
+	}
+	return 0, "", fmt.Errorf("unterminated quoted string")
+}
+
+// List returns the elements of the comma-separated list held by every field
+// named name, see ParseList. It returns no elements if the header is absent.
+func (h *Headers) List(name string) ([]string, error) {
+	var elements []string
+	for _, value := range h.Values(name) {
+		e, err := ParseList(value)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, e...)
+	}
+	return elements, nil
+}
+
+// ParseList splits a comma-separated list into its trimmed elements. Commas
+// inside quoted strings don't split and the quotes are kept, empty elements
+// are skipped.
+func ParseList(value string) ([]string, error) {
+	var elements []string
+	start := 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) && value[i] == '"' {
+			n, _, err := readQuoted(value[i:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %q: %v", ErrorInvalidList, value, err)
+			}
+			i += n - 1
+			continue
+		}
+		if i == len(value) || value[i] == ',' {
+			if element := strings.Trim(value[start:i], " \t"); element != "" {
+				elements = append(elements, element)
+			}
+			start = i + 1
+		}
+	}
+	return elements, nil
+}
+
+// Date returns the value of the date header name, such as Date or
+// If-Modified-Since, see ParseDate.
+func (h *Headers) Date(name string) (time.Time, error) {
+	values := h.Values(name)
+	if len(values) == 0 {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrorMissingHeader, name)
+	}
+	if len(values) > 1 {
+		return time.Time{}, fmt.Errorf("%w: repeated %s", ErrorInvalidDate, name)
+	}
+
+	return ParseDate(values[0])
+}
+
+// ParseDate parses an HTTP date. Dates are sent as IMF-fixdate, but the
+// obsolete RFC 850 and asctime formats are accepted too as RFC 9110 requires.
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range []string{TimeFormat, rfc850Format, asctimeFormat} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%w: %q", ErrorInvalidDate, value)
+}
+
+// FormatDate formats t as an IMF-fixdate
+func FormatDate(t time.Time) string {
+	return t.UTC().Format(TimeFormat)
+}
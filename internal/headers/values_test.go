@@ -0,0 +1,108 @@
+package headers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLength(t *testing.T) {
+	// Test: Valid length
+	h := NewHeaders()
+	h.Set("Content-Length", "42")
+	n, err := h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	// Test: Repeated identical lengths
+	h.Add("content-length", "42, 42")
+	n, err = h.ContentLength()
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), n)
+
+	// Test: Missing
+	_, err = NewHeaders().ContentLength()
+	assert.ErrorIs(t, err, ErrorMissingHeader)
+
+	// Test: Invalid lengths
+	for _, value := range []string{"", "abc", "-1", "+1", "0x10", "1 2", "1, 2", "99999999999999999999"} {
+		h = NewHeaders()
+		h.Set("Content-Length", value)
+		_, err = h.ContentLength()
+		assert.ErrorIs(t, err, ErrorInvalidContentLength, value)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	// Test: Media type with parameters
+	h := NewHeaders()
+	h.Set("Content-Type", `Text/HTML; Charset=utf-8; boundary="a; b\"c"`)
+	mediaType, params, err := h.ContentType()
+	require.NoError(t, err)
+	assert.Equal(t, "text/html", mediaType)
+	assert.Equal(t, map[string]string{"charset": "utf-8", "boundary": `a; b"c`}, params)
+
+	// Test: Missing
+	_, _, err = NewHeaders().ContentType()
+	assert.ErrorIs(t, err, ErrorMissingHeader)
+
+	// Test: Invalid media types
+	for _, value := range []string{"", "text", "text/", "/html", "te xt/html", "text/html/x"} {
+		_, _, err = ParseMediaType(value)
+		assert.ErrorIs(t, err, ErrorInvalidMediaType, value)
+	}
+
+	// Test: Invalid parameters
+	for _, value := range []string{"text/html; charset", "text/html; =utf-8", "text/html; a=1; a=2", `text/html; a="open`, "text/html; a=1 b=2", "text/html; a=x/y"} {
+		_, _, err = ParseMediaType(value)
+		assert.ErrorIs(t, err, ErrorInvalidParameter, value)
+	}
+}
+
+func TestList(t *testing.T) {
+	// Test: Elements across fields, quoted commas and empty elements
+	h := NewHeaders()
+	h.Add("If-None-Match", `"a,b" , W/"c"`)
+	h.Add("if-none-match", `,, "d"`)
+	elements, err := h.List("If-None-Match")
+	require.NoError(t, err)
+	assert.Equal(t, []string{`"a,b"`, `W/"c"`, `"d"`}, elements)
+
+	// Test: Missing header
+	elements, err = h.List("Connection")
+	require.NoError(t, err)
+	assert.Empty(t, elements)
+
+	// Test: Unterminated quoted string
+	_, err = ParseList(`"a, b`)
+	assert.ErrorIs(t, err, ErrorInvalidList)
+}
+
+func TestDate(t *testing.T) {
+	want := time.Date(1994, time.November, 6, 8, 49, 37, 0, time.UTC)
+
+	// Test: IMF-fixdate and the obsolete formats
+	for _, value := range []string{"Sun, 06 Nov 1994 08:49:37 GMT", "Sunday, 06-Nov-94 08:49:37 GMT", "Sun Nov  6 08:49:37 1994"} {
+		got, err := ParseDate(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, want, got, value)
+	}
+	assert.Equal(t, "Sun, 06 Nov 1994 08:49:37 GMT", FormatDate(want.In(time.FixedZone("EST", -5*3600))))
+
+	// Test: Header accessor
+	h := NewHeaders()
+	h.Set("Date", FormatDate(want))
+	got, err := h.Date("date")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	_, err = h.Date("If-Modified-Since")
+	assert.ErrorIs(t, err, ErrorMissingHeader)
+
+	// Test: Invalid dates
+	for _, value := range []string{"", "yesterday", "Sun, 06 Nov 1994 08:49:37 PST", "1994-11-06T08:49:37Z"} {
+		_, err = ParseDate(value)
+		assert.ErrorIs(t, err, ErrorInvalidDate, value)
+	}
+}
@@ -0,0 +1,86 @@
+package request
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrorBodyClosed = fmt.Errorf("read on closed body")
+var ErrorBodyNotDrained = fmt.Errorf("too much of the body left unread")
+
+// maxDrain bounds how much of an unread body DiscardBody reads just to reuse
+// the connection
+const maxDrain = 256 << 10
+
+// noBody is the Body of requests that carry no payload
+type noBody struct{}
+
+func (noBody) Read(p []byte) (int, error) { return 0, io.EOF }
+func (noBody) Close() error               { return nil }
+
+// bodyReader decodes a request body straight off the connection, pulling
+// more bytes from the parser only when the caller asks for them.
+type bodyReader struct {
+	parser  *Parser
+	request *Request
+	closed  bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.closed {
+		return 0, ErrorBodyClosed
+	}
+
+	r := b.request
+	for len(r.pending) == 0 {
+		if r.state == StateDone {
+			return 0, io.EOF
+		}
+
+		if err := b.parser.step(r); err != nil {
+			// The connection ended before the body did
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+
+	return n, nil
+}
+
+// Close stops the handler from reading any further. Whatever is left of the
+// body is discarded by the parser before the next request.
+func (b *bodyReader) Close() error {
+	b.closed = true
+	return nil
+}
+
+// DiscardBody drops whatever the handler left unread of the body, so the
+// connection can carry another request. It gives up with
+// ErrorBodyNotDrained once more than 256 KiB would have to be read, then the
+// connection is better closed.
+func (r *Request) DiscardBody() error {
+	if r.parser == nil {
+		return nil
+	}
+	return r.parser.discardBody(r, maxDrain)
+}
+
+// CanDiscardBody reports whether what is left of a Content-Length body is
+// small enough for DiscardBody. The size of a chunked body isn't known up
+// front, so it is assumed to fit.
+func (r *Request) CanDiscardBody() bool {
+	return r.state != StateBody || r.bodyLength-r.bodyRead <= maxDrain
+}
+
+// ReadBody reads the whole body into memory. It is meant for small payloads,
+// larger ones should be streamed from Body instead.
+func (r *Request) ReadBody() ([]byte, error) {
+	defer r.Body.Close()
+	return io.ReadAll(r.Body)
+}
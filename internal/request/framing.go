@@ -0,0 +1,59 @@
+package request
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var ErrorAmbiguousFraming = fmt.Errorf("both Transfer-Encoding and Content-Length present")
+var ErrorDuplicateContentLength = fmt.Errorf("more than one Content-Length")
+var ErrorInvalidTransferEncoding = fmt.Errorf("invalid Transfer-Encoding")
+var ErrorUnsupportedTransferCoding = fmt.Errorf("unsupported transfer coding")
+
+// bodyFraming works out how the body is delimited, either chunked or by a
+// Content-Length, 0 without one. Anything another parser on the path could
+// read differently is rejected, since that disagreement is what request
+// smuggling exploits: both headers at once, several lengths, or codings
+// other than a single chunked.
+func (r *Request) bodyFraming() (bool, int, error) {
+	lengths := r.Headers.Values("content-length")
+	encodings := r.Headers.Values("transfer-encoding")
+
+	if len(encodings) > 0 {
+		if len(lengths) > 0 {
+			return false, 0, ErrorAmbiguousFraming
+		}
+
+		codings, err := r.Headers.List("transfer-encoding")
+		if err != nil || len(codings) == 0 {
+			return false, 0, fmt.Errorf("%w: %q", ErrorInvalidTransferEncoding, strings.Join(encodings, ", "))
+		}
+		for i, coding := range codings {
+			if !strings.EqualFold(coding, "chunked") {
+				return false, 0, fmt.Errorf("%w: %q", ErrorUnsupportedTransferCoding, coding)
+			}
+			if i > 0 {
+				// chunked must not be applied twice
+				return false, 0, fmt.Errorf("%w: %q", ErrorInvalidTransferEncoding, strings.Join(encodings, ", "))
+			}
+		}
+		return true, 0, nil
+	}
+
+	if len(lengths) == 0 {
+		return false, 0, nil
+	}
+	if len(lengths) > 1 || strings.Contains(lengths[0], ",") {
+		return false, 0, fmt.Errorf("%w: %q", ErrorDuplicateContentLength, strings.Join(lengths, ", "))
+	}
+	length, err := r.Headers.ContentLength()
+	if err != nil {
+		return false, 0, err
+	}
+	if length > math.MaxInt {
+		return false, 0, ErrorBodyTooLarge
+	}
+
+	return false, int(length), nil
+}
@@ -0,0 +1,72 @@
+package request
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+var ErrorMissingHost = fmt.Errorf("missing Host header")
+var ErrorDuplicateHost = fmt.Errorf("more than one Host header")
+var ErrorInvalidHost = fmt.Errorf("invalid Host header")
+
+// Host returns the value of the Host header, which the parser guarantees to
+// be present exactly once.
+func (r *Request) Host() string {
+	host, _ := r.Headers.Get("host")
+	return host
+}
+
+// checkHost enforces the single, well formed Host header HTTP/1.1 requires
+func (r *Request) checkHost() error {
+	hosts := r.Headers.Values("host")
+	switch {
+	case len(hosts) == 0:
+		return ErrorMissingHost
+	case len(hosts) > 1:
+		return ErrorDuplicateHost
+	case !validHost(hosts[0]):
+		return fmt.Errorf("%w: %q", ErrorInvalidHost, hosts[0])
+	}
+	return nil
+}
+
+// validHost checks uri-host [ ":" port ]. An empty value is allowed, it is
+// what a client sends when the target has no authority.
+func validHost(host string) bool {
+	if host == "" {
+		return true
+	}
+
+	name, port := host, ""
+	if i := strings.LastIndexByte(host, ':'); i != -1 && !strings.HasSuffix(host, "]") {
+		name, port = host[:i], host[i+1:]
+		if strings.Trim(port, "0123456789") != "" {
+			return false
+		}
+	}
+
+	if strings.HasPrefix(name, "[") {
+		// IP-literal, only IPv6 addresses are supported
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(name, "["), "]"))
+		return strings.HasSuffix(name, "]") && ip != nil && ip.To4() == nil
+	}
+
+	// reg-name, which covers IPv4 addresses as well
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.IndexByte("-._~!$&'()*+,;=", c) != -1:
+		case c == '%' && i+2 < len(name) && isHex(name[i+1]) && isHex(name[i+2]):
+			i += 2
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
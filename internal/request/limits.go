@@ -0,0 +1,38 @@
+package request
+
+import "fmt"
+
+// Limits bounds how much of a request the parser is willing to buffer. A zero
+// or negative value for any field means that dimension is not limited.
+type Limits struct {
+	MaxRequestLine int
+	MaxHeaderBytes int
+	MaxHeaderCount int
+	MaxBody        int
+}
+
+// DefaultLimits leaves the body unbounded since it is streamed to the
+// handler rather than buffered by the parser.
+var DefaultLimits = Limits{
+	MaxRequestLine: 8 * 1024,
+	MaxHeaderBytes: 64 * 1024,
+	MaxHeaderCount: 100,
+}
+
+var ErrorRequestLineTooLong = fmt.Errorf("request line too long")
+var ErrorHeadersTooLarge = fmt.Errorf("header section too large")
+var ErrorBodyTooLarge = fmt.Errorf("body too large")
+
+// maxChunkLine bounds a chunk-size line including its extensions
+const maxChunkLine = 4096
+
+func exceeds(limit, n int) bool {
+	return limit > 0 && n > limit
+}
+
+// exceedsRemaining reports whether n more on top of used goes over limit.
+// It compares against what is left instead of adding, since n can be as
+// large as a client cares to announce.
+func exceedsRemaining(limit, used, n int) bool {
+	return limit > 0 && n > limit-used
+}
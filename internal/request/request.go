@@ -2,9 +2,13 @@ package request
 
 import (
 	"bytes"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"strconv"
+	"strings"
 
 	"github.com/trial-pyth/httpfromtcp/internal/headers"
 )
@@ -14,9 +18,28 @@ type parserState string
 type Request struct {
 	RequestLine RequestLine
 	Headers     *headers.Headers
-	Body        string
-
-	state parserState
+	// Body streams the payload from the connection as the handler reads it.
+	// Trailers are only filled in once Body has been read to the end.
+	Body     io.ReadCloser
+	Trailers *headers.Headers
+	// RemoteAddr is the address of the client as reported by its
+	// connection. It is set by the server, nil for requests read elsewhere.
+	RemoteAddr net.Addr
+	// TLS describes the connection the request arrived on, nil over plain
+	// HTTP
+	TLS *tls.ConnectionState
+
+	state          parserState
+	chunkRemaining int
+	limits         Limits
+	headerBytes    int
+	bodyLength     int
+	bodyRead       int
+	// pending holds decoded body bytes not yet handed out by Body
+	pending []byte
+	parser  *Parser
+	// pathValues holds the wildcards matched by a router
+	pathValues map[string]string
 }
 
 type RequestLine struct {
@@ -28,13 +51,16 @@ type RequestLine struct {
 var ErrorMalformedRequestLine = fmt.Errorf("malformed request line")
 var ErrorUnsupportedHttpVersion = fmt.Errorf("unsupported http version")
 var ErrorRequestInErrorState = fmt.Errorf("request in error state")
+var ErrorMalformedChunk = fmt.Errorf("malformed chunk")
 var SEPARATOR = []byte("\r\n")
 
-func newRequest() *Request {
+func newRequest(limits Limits) *Request {
 	return &Request{
-		state:   StateInit,
-		Headers: headers.NewHeaders(),
-		Body:    "",
+		limits:   limits,
+		state:    StateInit,
+		Headers:  headers.NewHeaders(),
+		Body:     noBody{},
+		Trailers: headers.NewHeaders(),
 	}
 }
 
@@ -42,23 +68,62 @@ func (r *RequestLine) ValidHTTP() bool {
 	return r.HttpVersion == "HTTP/1.1"
 }
 
-func getInt(headers *headers.Headers, name string, defaultValue int) int {
-	valueStr, exists := headers.Get(name)
-	if !exists {
-		return defaultValue
+// forbiddenTrailers are fields a sender must not put in a trailer section,
+// since they control framing, routing or authentication of the message.
+var forbiddenTrailers = []string{
+	"authorization", "cache-control", "content-encoding", "content-length",
+	"content-range", "content-type", "expect", "host", "max-forwards",
+	"proxy-authorization", "range", "te", "trailer", "transfer-encoding",
+}
+
+// filterTrailers drops every trailer field that was not announced in the
+// Trailer request header or that is not allowed in trailers at all.
+func (r *Request) filterTrailers() {
+	allowed := map[string]bool{}
+	declared, _ := r.Headers.List("trailer")
+	for _, name := range declared {
+		allowed[strings.ToLower(name)] = true
+	}
+	for _, name := range forbiddenTrailers {
+		delete(allowed, name)
 	}
 
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		return defaultValue
+	drop := []string{}
+	r.Trailers.ForEach(func(k, v string) {
+		if !allowed[strings.ToLower(k)] {
+			drop = append(drop, k)
+		}
+	})
+	for _, name := range drop {
+		r.Trailers.Delete(name)
 	}
+}
+
+// PathValue returns the value of a named path parameter set by a router, or
+// an empty string if there is none.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
 
-	return value
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = map[string]string{}
+	}
+	r.pathValues[name] = value
 }
 
-func (r *Request) hasBody() bool {
-	length := getInt(r.Headers, "content-length", 0)
-	return length > 0
+// KeepAlive reports whether the client is willing to send further requests
+// on the same connection. HTTP/1.1 connections persist unless the client
+// sends "Connection: close".
+func (r *Request) KeepAlive() bool {
+	options, _ := r.Headers.List("connection")
+	for _, option := range options {
+		if strings.EqualFold(option, "close") {
+			return false
+		}
+	}
+
+	return true
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -80,6 +145,14 @@ outer:
 				r.state = StateError
 				return 0, err
 			}
+			lineLen := n - len(SEPARATOR)
+			if n == 0 {
+				lineLen = len(currentData)
+			}
+			if exceeds(r.limits.MaxRequestLine, lineLen) {
+				r.state = StateError
+				return 0, ErrorRequestLineTooLong
+			}
 			if n == 0 {
 				break outer
 			}
@@ -95,47 +168,142 @@ outer:
 				return 0, err
 			}
 
+			// Count what is still buffered too, so an endless header line
+			// is caught before it has been terminated
+			r.headerBytes += n
+			pending := 0
+			if !done {
+				pending = len(currentData) - n
+			}
+			if exceeds(r.limits.MaxHeaderBytes, r.headerBytes+pending) ||
+				exceeds(r.limits.MaxHeaderCount, r.Headers.Len()) {
+				r.state = StateError
+				return 0, ErrorHeadersTooLarge
+			}
+
 			if n == 0 {
 				break outer
 			}
 
 			read += n
 			if done {
-				if r.hasBody() {
+				// Hand the request out as soon as the headers are in, the
+				// body is decoded later as it gets read
+				chunked, length, err := r.bodyFraming()
+				if err == nil && exceeds(r.limits.MaxBody, length) {
+					err = ErrorBodyTooLarge
+				}
+				if err == nil {
+					err = r.checkHost()
+				}
+				if err != nil {
+					r.state = StateError
+					return 0, err
+				}
+				if chunked {
+					r.state = StateChunkSize
+					break outer
+				}
+				r.bodyLength = length
+				if length > 0 {
 					r.state = StateBody
 				} else {
 					r.state = StateDone
 				}
-
 				break outer
 			}
 
 		case StateBody:
-			length := getInt(r.Headers, "content-length", 0)
-			if length == 0 {
-				panic("chunk not implmented")
+			remaining := min(r.bodyLength-r.bodyRead, len(currentData))
+			r.pending = append(r.pending, currentData[:remaining]...)
+			r.bodyRead += remaining
+			read += remaining
+
+			if r.bodyRead == r.bodyLength {
+				r.state = StateDone
+				break outer
+			}
+
+		case StateChunkSize:
+			size, n, err := parseChunkSize(currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+
+			if n == 0 {
+				break outer
 			}
 
-			remaining := min(length-len(r.Body), len(currentData))
-			r.Body += string(currentData[:remaining])
+			if exceedsRemaining(r.limits.MaxBody, r.bodyRead, size) {
+				r.state = StateError
+				return 0, ErrorBodyTooLarge
+			}
+
+			read += n
+			r.chunkRemaining = size
+			if size == 0 {
+				r.state = StateTrailers
+			} else {
+				r.state = StateChunkData
+			}
+
+		case StateChunkData:
+			if exceedsRemaining(r.limits.MaxBody, r.bodyRead, r.chunkRemaining) {
+				r.state = StateError
+				return 0, ErrorBodyTooLarge
+			}
+
+			remaining := min(r.chunkRemaining, len(currentData))
+			r.pending = append(r.pending, currentData[:remaining]...)
+			r.chunkRemaining -= remaining
+			r.bodyRead += remaining
 			read += remaining
 
-			if len(r.Body) > length {
-				return 0, fmt.Errorf("body length %d exceeds content-length %d", len(r.Body), length)
+			if r.chunkRemaining == 0 {
+				r.state = StateChunkEnd
 			}
 
-			if len(r.Body) == length {
-				r.state = StateDone
+		case StateChunkEnd:
+			// Every chunk's data is terminated by its own CRLF
+			if len(currentData) < len(SEPARATOR) {
 				break outer
 			}
 
-			// If we've processed all available data but don't have the full body yet, break to read more
-			if remaining == 0 {
+			if !bytes.HasPrefix(currentData, SEPARATOR) {
+				r.state = StateError
+				return 0, ErrorMalformedChunk
+			}
+
+			read += len(SEPARATOR)
+			r.state = StateChunkSize
+
+		case StateTrailers:
+			n, done, err := r.Trailers.Parse(currentData)
+			if err != nil {
+				r.state = StateError
+				return 0, err
+			}
+
+			r.headerBytes += n
+			pending := 0
+			if !done {
+				pending = len(currentData) - n
+			}
+			if exceeds(r.limits.MaxHeaderBytes, r.headerBytes+pending) ||
+				exceeds(r.limits.MaxHeaderCount, r.Trailers.Len()) {
+				r.state = StateError
+				return 0, ErrorHeadersTooLarge
+			}
+
+			if n == 0 {
 				break outer
 			}
 
-			// If we've processed all available data but don't have the full body yet, break to read more
-			if remaining == 0 || len(currentData) == 0 {
+			read += n
+			if done {
+				r.filterTrailers()
+				r.state = StateDone
 				break outer
 			}
 
@@ -159,8 +327,41 @@ const (
 	StateBody    parserState = "body"
 	StateHeaders parserState = "headers"
 	StateError   parserState = "error"
+
+	StateChunkSize parserState = "chunk-size"
+	StateChunkData parserState = "chunk-data"
+	StateChunkEnd  parserState = "chunk-end"
+	StateTrailers  parserState = "trailers"
 )
 
+// parseChunkSize reads a chunk-size line, ignoring any chunk extensions.
+// It returns 0 bytes read if the line is not complete yet.
+func parseChunkSize(b []byte) (int, int, error) {
+	idx := bytes.Index(b, SEPARATOR)
+	if idx == -1 {
+		if len(b) > maxChunkLine {
+			return 0, 0, ErrorMalformedChunk
+		}
+		return 0, 0, nil
+	}
+	if idx > maxChunkLine {
+		return 0, 0, ErrorMalformedChunk
+	}
+	line := b[:idx]
+	read := idx + len(SEPARATOR)
+
+	if ext := bytes.IndexByte(line, ';'); ext != -1 {
+		line = bytes.TrimRight(line[:ext], " \t")
+	}
+
+	size, err := strconv.ParseUint(string(line), 16, strconv.IntSize-1)
+	if err != nil {
+		return 0, 0, ErrorMalformedChunk
+	}
+
+	return int(size), read, nil
+}
+
 func parseRequestLine(b []byte) (*RequestLine, int, error) {
 	idx := bytes.Index(b, SEPARATOR)
 	if idx == -1 {
@@ -189,48 +390,127 @@ func parseRequestLine(b []byte) (*RequestLine, int, error) {
 
 }
 
-func RequestFromReader(reader io.Reader) (*Request, error) {
-	request := newRequest()
-	buf := make([]byte, 4096)
-	bufLen := 0
-	for !request.done() {
-		n, err := reader.Read(buf[bufLen:])
-		if err != nil {
-			if err == io.EOF {
-				// EOF - try to parse what we have
-				if bufLen > 0 {
-					readN, parseErr := request.parse(buf[:bufLen])
-					if parseErr != nil {
-						return nil, parseErr
-					}
-					// If parsing consumed data and request is done, return it
-					if readN > 0 && request.done() {
-						return request, nil
-					}
-				}
-				// EOF and no data or incomplete request - this is a connection that closed without sending
-				return nil, err
-			}
-			return nil, err
-		}
+// Parser reads consecutive requests from a single connection. Bytes that
+// arrive after the end of one request are kept for the next one, which is
+// what makes pipelined requests work.
+type Parser struct {
+	reader  io.Reader
+	buf     []byte
+	bufLen  int
+	limits  Limits
+	readErr error
+	current *Request
+}
 
-		if n == 0 {
-			// No data read - this shouldn't happen normally, but handle it
-			break
-		}
+func NewParser(reader io.Reader) *Parser {
+	return NewParserWithLimits(reader, DefaultLimits)
+}
 
-		bufLen += n
-		readN, err := request.parse(buf[:bufLen])
-		if err != nil {
+func NewParserWithLimits(reader io.Reader, limits Limits) *Parser {
+	return &Parser{
+		reader: reader,
+		buf:    make([]byte, 4096),
+		limits: limits,
+	}
+}
+
+// Next reads the request line and headers of the next request from the
+// connection; its body is read on demand through Request.Body. Whatever is
+// left unread of the previous request's body is discarded first. Next
+// returns io.EOF if the connection was closed before a complete request
+// arrived.
+func (p *Parser) Next() (*Request, error) {
+	if p.current != nil {
+		if err := p.discardBody(p.current, 0); err != nil {
 			return nil, err
 		}
+	}
 
-		// Shift remaining data to the beginning of the buffer
-		if readN > 0 {
-			copy(buf, buf[readN:bufLen])
-			bufLen -= readN
+	request := newRequest(p.limits)
+	for request.state == StateInit || request.state == StateHeaders {
+		if err := p.step(request); err != nil {
+			return nil, err
 		}
 	}
 
+	if request.state != StateDone {
+		request.Body = &bodyReader{parser: p, request: request}
+	}
+	request.parser = p
+	p.current = request
+
 	return request, nil
 }
+
+// step feeds everything buffered to the request parser and, if that made no
+// progress, reads once more from the connection.
+func (p *Parser) step(request *Request) error {
+	if request.state == StateError {
+		return ErrorRequestInErrorState
+	}
+
+	readN, err := request.parse(p.buf[:p.bufLen])
+	if err != nil {
+		return err
+	}
+
+	// Shift remaining data to the beginning of the buffer
+	if readN > 0 {
+		copy(p.buf, p.buf[readN:p.bufLen])
+		p.bufLen -= readN
+		return nil
+	}
+
+	// Only give up once everything read before the error has been parsed
+	if p.readErr != nil {
+		return p.readErr
+	}
+
+	// A single line may not fit, the limits keep this from growing forever
+	if p.bufLen == len(p.buf) {
+		buf := make([]byte, len(p.buf)*2)
+		copy(buf, p.buf[:p.bufLen])
+		p.buf = buf
+	}
+
+	n, err := p.reader.Read(p.buf[p.bufLen:])
+	p.bufLen += n
+	p.readErr = err
+
+	if n == 0 && err == nil {
+		return io.ErrNoProgress
+	}
+
+	return nil
+}
+
+// discardBody reads and drops the rest of a request's body so the
+// connection is positioned at the start of the next request. It fails with
+// ErrorBodyNotDrained if that takes more than limit bytes, 0 means no limit.
+func (p *Parser) discardBody(request *Request, limit int) error {
+	// A Content-Length body tells up front whether it fits
+	if request.state == StateBody && exceeds(limit, request.bodyLength-request.bodyRead) {
+		return ErrorBodyNotDrained
+	}
+
+	start := request.bodyRead
+	for request.state != StateDone {
+		if exceeds(limit, request.bodyRead-start) {
+			return ErrorBodyNotDrained
+		}
+		request.pending = request.pending[:0]
+		if err := p.step(request); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	request.pending = nil
+
+	return nil
+}
+
+func RequestFromReader(reader io.Reader) (*Request, error) {
+	return NewParser(reader).Next()
+}
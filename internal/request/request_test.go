@@ -2,11 +2,13 @@ package request
 
 import (
 	"io"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/headers"
 )
 
 type chunkReader struct {
@@ -31,6 +33,13 @@ func (cr *chunkReader) Read(p []byte) (n int, err error) {
 	return n, nil
 }
 
+func readBody(t *testing.T, r *Request) string {
+	t.Helper()
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	return string(body)
+}
+
 func TestRequestLineParse(t *testing.T) {
 	// Test: Good GET Request line
 	reader := &chunkReader{
@@ -78,15 +87,13 @@ func TestHeadersParse(t *testing.T) {
 	assert.Equal(t, "curl/7.81.0", userAgent)
 	assert.Equal(t, "*/*", accept)
 
-	// Test: Empty Headers
+	// Test: Empty Headers, HTTP/1.1 requires Host
 	reader = &chunkReader{
 		data:            "GET / HTTP/1.1\r\n\r\n",
 		numBytesPerRead: 3,
 	}
-	r, err = RequestFromReader(reader)
-	require.NoError(t, err)
-	require.NotNil(t, r)
-	assert.Equal(t, 0, r.Headers.Len())
+	_, err = RequestFromReader(reader)
+	require.ErrorIs(t, err, ErrorMissingHost)
 
 	// Test: Malformed Header
 	reader = &chunkReader{
@@ -105,7 +112,9 @@ func TestHeadersParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 }
 
@@ -122,7 +131,7 @@ func TestBodyParse(t *testing.T) {
 	r, err := RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "hello world!\n", string(r.Body))
+	assert.Equal(t, "hello world!\n", readBody(t, r))
 
 	// Test: Empty Body, 0 reported content length
 	reader = &chunkReader{
@@ -135,7 +144,7 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
 
 	// Test: Body shorter than reported content length
 	reader = &chunkReader{
@@ -146,7 +155,9 @@ func TestBodyParse(t *testing.T) {
 			"partial content",
 		numBytesPerRead: 3,
 	}
-	_, err = RequestFromReader(reader)
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
 	require.Error(t, err)
 
 	// Test: No Content-Length but Body Exists
@@ -160,5 +171,501 @@ func TestBodyParse(t *testing.T) {
 	r, err = RequestFromReader(reader)
 	require.NoError(t, err)
 	require.NotNil(t, r)
-	assert.Equal(t, "", string(r.Body))
+	assert.Equal(t, "", readBody(t, r))
+
+	// Test: Malformed Content-Length
+	for _, length := range []string{"abc", "-1", "+5", "1 2", ""} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: " + length + "\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 3,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorIs(t, err, headers.ErrorInvalidContentLength, length)
+	}
+
+}
+
+func TestSmuggling(t *testing.T) {
+	// Payloads modeled on the classic smuggling attacks, where a front end
+	// and this server would disagree on where the first request ends
+	cases := []struct {
+		name string
+		data string
+		err  error
+	}{
+		{"CL.TE", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 13\r\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\nSMUGGLED", ErrorAmbiguousFraming},
+		{"TE.CL", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 3\r\nTransfer-Encoding: chunked\r\n\r\n8\r\nSMUGGLED\r\n0\r\n\r\n", ErrorAmbiguousFraming},
+		{"CL.CL", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\nContent-Length: 7\r\n\r\nSMUGGLED", ErrorDuplicateContentLength},
+		{"CL.CL identical", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8\r\nContent-Length: 8\r\n\r\nSMUGGLED", ErrorDuplicateContentLength},
+		{"CL list", "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 8, 8\r\n\r\nSMUGGLED", ErrorDuplicateContentLength},
+		{"TE.TE unknown coding", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: xchunked\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferCoding},
+		{"TE.TE second field", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\nTransfer-Encoding: x\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferCoding},
+		{"TE.TE chunked not last", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, identity\r\n\r\n0\r\n\r\n", ErrorUnsupportedTransferCoding},
+		{"TE.TE chunked twice", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked, chunked\r\n\r\n0\r\n\r\n", ErrorInvalidTransferEncoding},
+		{"TE.TE empty", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: ,\r\n\r\n0\r\n\r\n", ErrorInvalidTransferEncoding},
+		{"TE.TE space before colon", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding : chunked\r\n\r\n0\r\n\r\n", headers.ErrorInvalidFieldName},
+		{"TE.TE leading space", "POST / HTTP/1.1\r\n Transfer-Encoding: chunked\r\nHost: localhost\r\n\r\n0\r\n\r\n", headers.ErrorInvalidFieldName},
+		{"TE.TE obs-fold", "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding:\r\n chunked\r\n\r\n0\r\n\r\n", headers.ErrorObsFold},
+		{"TE.TE bare LF", "POST / HTTP/1.1\r\nHost: localhost\r\nX: X\nTransfer-Encoding: chunked\r\n\r\n0\r\n\r\n", headers.ErrorInvalidFieldValue},
+	}
+	for _, c := range cases {
+		_, err := RequestFromReader(&chunkReader{data: c.data, numBytesPerRead: 3})
+		assert.ErrorIs(t, err, c.err, c.name)
+	}
+
+	// Test: Obfuscations of a valid chunked encoding are still chunked
+	for _, te := range []string{"Chunked", "\tchunked", "chunked "} {
+		r, err := RequestFromReader(&chunkReader{
+			data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding:" + te + "\r\n\r\n5\r\nhello\r\n0\r\n\r\n",
+			numBytesPerRead: 3,
+		})
+		require.NoError(t, err, te)
+		assert.Equal(t, "hello", readBody(t, r), te)
+	}
+}
+
+func TestChunkedBodyParse(t *testing.T) {
+	// Test: Standard chunked body
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"7\r\n world!\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello world!", readBody(t, r))
+
+	// Test: Chunk sizes in hex with extensions, one byte per read
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"a;name=value\r\n0123456789\r\n" +
+			"1A ; foo\r\nabcdefghijklmnopqrstuvwxyz\r\n" +
+			"0;last\r\n" +
+			"\r\n",
+		numBytesPerRead: 1,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "0123456789abcdefghijklmnopqrstuvwxyz", readBody(t, r))
+
+	// Test: Empty chunked body
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 4,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "", readBody(t, r))
+
+	// Test: Chunk data longer than its declared size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"3\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrorMalformedChunk)
+
+	// Test: Invalid chunk size
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"-5\r\nhello\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrorMalformedChunk)
+
+	// Test: Missing terminating chunk
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestTrailersParse(t *testing.T) {
+	// Test: Declared trailers are parsed
+	reader := &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Content-Sha256, X-Content-Length\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"0\r\n" +
+			"X-Content-Sha256: 2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\r\n" +
+			"X-Content-Length: 5\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, 2, r.Trailers.Len())
+	digest, ok := r.Trailers.Get("x-content-sha256")
+	assert.True(t, ok)
+	assert.Equal(t, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", digest)
+	length, ok := r.Trailers.Get("x-content-length")
+	assert.True(t, ok)
+	assert.Equal(t, "5", length)
+
+	// Test: Undeclared and forbidden trailers are dropped
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum, Content-Length\r\n" +
+			"\r\n" +
+			"5\r\nhello\r\n" +
+			"0\r\n" +
+			"X-Checksum: abc\r\n" +
+			"X-Other: def\r\n" +
+			"Content-Length: 100\r\n" +
+			"\r\n",
+		numBytesPerRead: 5,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello", readBody(t, r))
+	assert.Equal(t, 1, r.Trailers.Len())
+	checksum, ok := r.Trailers.Get("x-checksum")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", checksum)
+
+	// Test: No trailers
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "", readBody(t, r))
+	assert.Equal(t, 0, r.Trailers.Len())
+
+	// Test: Malformed trailer
+	reader = &chunkReader{
+		data: "POST /upload HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Trailer: X-Checksum\r\n" +
+			"\r\n" +
+			"0\r\n" +
+			"X-Checksum abc\r\n" +
+			"\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.Error(t, err)
+}
+
+func TestParserPipelining(t *testing.T) {
+	// Test: Several requests in a single stream
+	reader := &chunkReader{
+		data: "GET /first HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"\r\n" +
+			"POST /second HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello" +
+			"POST /third HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"\r\n" +
+			"5\r\nworld\r\n" +
+			"0\r\n" +
+			"\r\n",
+		numBytesPerRead: 7,
+	}
+	p := NewParser(reader)
+	r, err := p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/first", r.RequestLine.RequestTarget)
+	assert.Equal(t, "", readBody(t, r))
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/second", r.RequestLine.RequestTarget)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/third", r.RequestLine.RequestTarget)
+	assert.Equal(t, "world", readBody(t, r))
+
+	_, err = p.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// Test: Whole stream delivered in one read
+	reader = &chunkReader{
+		data: "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 4096,
+	}
+	p = NewParser(reader)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/a", r.RequestLine.RequestTarget)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: Connection closed in the middle of the second request
+	reader = &chunkReader{
+		data: "GET /a HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: loc",
+		numBytesPerRead: 3,
+	}
+	p = NewParser(reader)
+	_, err = p.Next()
+	require.NoError(t, err)
+	_, err = p.Next()
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestStreamingBody(t *testing.T) {
+	// Test: Request is returned before the body has arrived
+	conn, client := io.Pipe()
+	go client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost:42069\r\nContent-Length: 10\r\n\r\n"))
+	r, err := RequestFromReader(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "/upload", r.RequestLine.RequestTarget)
+
+	go func() {
+		client.Write([]byte("hello"))
+		client.Write([]byte("world"))
+	}()
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	_, err = io.ReadFull(r.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+	n, err := r.Body.Read(buf)
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+
+	// Test: Reading after Close fails
+	reader := &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NoError(t, r.Body.Close())
+	_, err = r.Body.Read(buf)
+	assert.ErrorIs(t, err, ErrorBodyClosed)
+
+	// Test: Truncated body reports an unexpected EOF
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 50\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Unread body is skipped before the next request
+	reader = &chunkReader{
+		data: "POST /a HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n" +
+			"GET /b HTTP/1.1\r\nHost: localhost\r\n\r\n",
+		numBytesPerRead: 2,
+	}
+	p := NewParser(reader)
+	_, err = p.Next()
+	require.NoError(t, err)
+	r, err = p.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "/b", r.RequestLine.RequestTarget)
+
+	// Test: DiscardBody skips a small unread body
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello"))
+	require.NoError(t, err)
+	assert.True(t, r.CanDiscardBody())
+	assert.NoError(t, r.DiscardBody())
+
+	// Test: DiscardBody gives up on a large Content-Length body without reading it
+	large := strings.Repeat("a", maxDrain+1)
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + strconv.Itoa(len(large)) + "\r\n\r\n" + large,
+		numBytesPerRead: 1024,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	assert.False(t, r.CanDiscardBody())
+	assert.ErrorIs(t, r.DiscardBody(), ErrorBodyNotDrained)
+	assert.Less(t, reader.pos, len(reader.data))
+
+	// Test: DiscardBody gives up on a chunked body once it has read too much
+	chunk := strconv.FormatInt(64<<10, 16) + "\r\n" + strings.Repeat("a", 64<<10) + "\r\n"
+	r, err = RequestFromReader(strings.NewReader("POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		strings.Repeat(chunk, 8) + "0\r\n\r\n"))
+	require.NoError(t, err)
+	assert.True(t, r.CanDiscardBody())
+	assert.ErrorIs(t, r.DiscardBody(), ErrorBodyNotDrained)
+}
+
+func TestLimits(t *testing.T) {
+	limits := Limits{
+		MaxRequestLine: 32,
+		MaxHeaderBytes: 64,
+		MaxHeaderCount: 3,
+		MaxBody:        10,
+	}
+
+	// Test: Request within every limit
+	reader := &chunkReader{
+		data:            "POST /ok HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\n\r\nhello",
+		numBytesPerRead: 3,
+	}
+	r, err := NewParserWithLimits(reader, limits).Next()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", readBody(t, r))
+
+	// Test: Request line too long, even before it is terminated
+	reader = &chunkReader{
+		data:            "GET /" + strings.Repeat("a", 100),
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	require.ErrorIs(t, err, ErrorRequestLineTooLong)
+
+	// Test: Header section too large
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("b", 100) + "\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	require.ErrorIs(t, err, ErrorHeadersTooLarge)
+
+	// Test: Too many headers
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nA: 1\r\nB: 2\r\nC: 3\r\nD: 4\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	require.ErrorIs(t, err, ErrorHeadersTooLarge)
+
+	// Test: Content-Length over the body limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 11\r\n\r\nhello world",
+		numBytesPerRead: 3,
+	}
+	_, err = NewParserWithLimits(reader, limits).Next()
+	require.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: Chunked body over the body limit
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n6\r\nhello \r\n6\r\nworld!\r\n0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err = NewParserWithLimits(reader, limits).Next()
+	require.NoError(t, err)
+	_, err = r.ReadBody()
+	require.ErrorIs(t, err, ErrorBodyTooLarge)
+
+	// Test: Huge chunk size after a chunk doesn't overflow the limit check
+	reader = &chunkReader{
+		data:            "POST / HTTP/1.1\r\nHost: localhost\r\nTransfer-Encoding: chunked\r\n\r\n1\r\na\r\n7fffffffffffffff\r\n" + strings.Repeat("b", 20000),
+		numBytesPerRead: 3,
+	}
+	r, err = NewParserWithLimits(reader, limits).Next()
+	require.NoError(t, err)
+	body, err := r.ReadBody()
+	require.ErrorIs(t, err, ErrorBodyTooLarge)
+	assert.LessOrEqual(t, len(body), limits.MaxBody)
+
+	// Test: Lines longer than the initial buffer
+	longValue := strings.Repeat("v", 10000)
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost\r\nX-Long: " + longValue + "\r\n\r\n",
+		numBytesPerRead: 1024,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	value, ok := r.Headers.Get("x-long")
+	assert.True(t, ok)
+	assert.Equal(t, longValue, value)
+}
+
+func TestHost(t *testing.T) {
+	parse := func(fields string) (*Request, error) {
+		return RequestFromReader(&chunkReader{data: "GET / HTTP/1.1\r\n" + fields + "\r\n", numBytesPerRead: 3})
+	}
+
+	// Test: Valid hosts
+	for _, host := range []string{"localhost", "Example.COM:8080", "127.0.0.1:42069", "[::1]", "[2001:db8::1]:443", "xn--bcher-kva.example", "a%2Db.example", ""} {
+		r, err := parse("Host: " + host + "\r\n")
+		require.NoError(t, err, host)
+		assert.Equal(t, host, r.Host(), host)
+	}
+
+	// Test: Missing and duplicate Host
+	_, err := parse("Accept: */*\r\n")
+	assert.ErrorIs(t, err, ErrorMissingHost)
+	_, err = parse("Host: a.example\r\nHost: b.example\r\n")
+	assert.ErrorIs(t, err, ErrorDuplicateHost)
+	_, err = parse("Host: a.example\r\nhost: a.example\r\n")
+	assert.ErrorIs(t, err, ErrorDuplicateHost)
+
+	// Test: Invalid hosts
+	for _, host := range []string{"a.example, b.example", "a b", "user@a.example", "a.example/path", "a.example:port", "::1", "[::1", "[127.0.0.1]", "a%zz.example", ":8080"} {
+		_, err = parse("Host: " + host + "\r\n")
+		assert.ErrorIs(t, err, ErrorInvalidHost, host)
+	}
 }
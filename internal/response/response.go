@@ -3,18 +3,32 @@ package response
 import (
 	"fmt"
 	"io"
+	"slices"
+	"strings"
 
 	"github.com/trial-pyth/httpfromtcp/internal/headers"
 )
 
 type Writer struct {
-	writer io.Writer
-	state  WriterState
+	writer     io.Writer
+	state      WriterState
+	close      bool
+	statusCode StatusCode
+	chunked    bool
+	bodyBytes  int
+	// contentLength is the body length announced in the headers, -1 if the
+	// body isn't delimited by Content-Length
+	contentLength int
+	// trailers holds the lowercased names announced in the Trailer header
+	trailers []string
+	// beforeHeaders run right before the headers go out
+	beforeHeaders []func()
 }
 
 func NewWriter(writer io.Writer) *Writer {
-	return &Writer{writer: writer}
+	return &Writer{writer: writer, state: WriteStateStatusLine, contentLength: -1}
 }
+
 type WriterState string
 
 const (
@@ -22,57 +36,305 @@ const (
 	WriteStateHeaders    WriterState = "Headers"
 	WriteStateBody       WriterState = "Body"
 	WriteStateTrailer    WriterState = "Trailer"
+	WriteStateDone       WriterState = "Done"
 )
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	statusLine := []byte{}
-	switch statusCode {
-	case StatusOK:
-		statusLine = []byte("HTTP/1.1 200 OK\r\n")
-	case StatusBadRequest:
-		statusLine = []byte("HTTP/1.1 400 Bad Request\r\n")
-	case StatusInternalServerError:
-		statusLine = []byte("HTTP/1.1 500 Internal Server Error\r\n")
+// expectState guards every write so the parts of a response can only go out
+// in order: status line, headers, then body.
+func (w *Writer) expectState(state WriterState, part string) error {
+	if w.state != state {
+		return fmt.Errorf("%w: cannot write %s after the %s", ErrorWriterState, part, w.written())
+	}
+	return nil
+}
+
+// written describes what has been sent so far, for error messages
+func (w *Writer) written() string {
+	switch w.state {
+	case WriteStateStatusLine:
+		return "start of the response"
+	case WriteStateHeaders:
+		return "status line"
+	case WriteStateBody:
+		return "headers"
+	case WriteStateTrailer:
+		return "last chunk"
 	default:
-		return fmt.Errorf("unrecognized error code")
+		return "end of the response"
+	}
+}
+
+var ErrorWriterState = fmt.Errorf("response written out of order")
+var ErrorNotChunked = fmt.Errorf("response is not chunked")
+var ErrorUndeclaredTrailer = fmt.Errorf("trailer not declared in Trailer header")
+var ErrorInvalidStatusCode = fmt.Errorf("status code must have three digits")
+var ErrorInvalidReasonPhrase = fmt.Errorf("invalid reason phrase")
+var ErrorBodyTooLong = fmt.Errorf("body longer than Content-Length")
+var ErrorBodyNotAllowed = fmt.Errorf("status does not allow a body")
+
+// WriteStatusLine writes the status line with the registered reason phrase
+// for statusCode. Unregistered codes are sent with an empty reason phrase.
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	return w.WriteStatusLineWithReason(statusCode, StatusText(statusCode))
+}
+
+// WriteStatusLineWithReason writes the status line with a custom reason
+// phrase. Any three-digit code is accepted.
+func (w *Writer) WriteStatusLineWithReason(statusCode StatusCode, reason string) error {
+	if err := w.expectState(WriteStateStatusLine, "status line"); err != nil {
+		return err
+	}
+	if statusCode < 100 || statusCode > 999 {
+		return ErrorInvalidStatusCode
 	}
+	for _, c := range []byte(reason) {
+		// reason-phrase = 1*( HTAB / SP / VCHAR / obs-text )
+		if c != '\t' && (c < ' ' || c == 0x7f) {
+			return ErrorInvalidReasonPhrase
+		}
+	}
+
+	statusLine := fmt.Appendf(nil, "HTTP/1.1 %03d %s\r\n", statusCode, reason)
 
-	_, err := w.writer.Write(statusLine)
+	w.statusCode = statusCode
+	w.state = WriteStateHeaders
+	_, err := w.write(statusLine)
 	return err
 }
+
+// Wrap replaces the destination of the response with wrap's result, so a
+// middleware can observe or transform the bytes on their way to the
+// connection.
+func (w *Writer) Wrap(wrap func(io.Writer) io.Writer) {
+	w.writer = wrap(w.writer)
+}
+
+// write sends b to the connection. A failed write leaves the response
+// truncated, so the connection can't be reused afterwards.
+func (w *Writer) write(b []byte) (int, error) {
+	n, err := w.writer.Write(b)
+	if err != nil {
+		w.close = true
+	}
+	return n, err
+}
+
+// BeforeHeaders registers fn to run right before the headers are written,
+// the last moment the response can still be changed, e.g. with
+// SetConnectionClose.
+func (w *Writer) BeforeHeaders(fn func()) {
+	w.beforeHeaders = append(w.beforeHeaders, fn)
+}
+
+// State returns which part of the response is due to be written next.
+func (w *Writer) State() WriterState {
+	return w.state
+}
+
+// StatusCode returns the status code that was written, or 0 if the status
+// line hasn't been written yet.
+func (w *Writer) StatusCode() StatusCode {
+	return w.statusCode
+}
+
+// BytesWritten returns how many body bytes have been written, not counting
+// chunk framing.
+func (w *Writer) BytesWritten() int {
+	return w.bodyBytes
+}
+
+// SetConnectionClose makes the response announce "Connection: close" so the
+// connection is torn down once the response has been written.
+func (w *Writer) SetConnectionClose() {
+	w.close = true
+}
+
+// KeepAlive reports whether the connection can be reused for another
+// request after this response, i.e. the headers have been sent, the body
+// matched its Content-Length or the chunked encoding was finished, and nobody
+// asked to close.
+func (w *Writer) KeepAlive() bool {
+	if w.close {
+		return false
+	}
+	return w.state == WriteStateDone ||
+		(w.state == WriteStateBody && !w.chunked && w.bodyBytes == w.contentLength)
+}
+
 func (w *Writer) WriteHeaders(headers headers.Headers) error {
+	if err := w.expectState(WriteStateHeaders, "headers"); err != nil {
+		return err
+	}
+	for _, fn := range w.beforeHeaders {
+		fn()
+	}
+
+	if v, ok := headers.Get("connection"); ok && strings.EqualFold(v, "close") {
+		w.close = true
+	}
+	_, hasLength := headers.Get("content-length")
+	if !bodyAllowed(w.statusCode) {
+		w.contentLength = 0
+	} else if hasLength {
+		if length, err := headers.ContentLength(); err == nil {
+			w.contentLength = int(length)
+		} else {
+			// Nobody can tell where a body with a broken length ends
+			w.close = true
+		}
+	}
+	te, hasEncoding := headers.Get("transfer-encoding")
+	w.chunked = hasEncoding && bodyAllowed(w.statusCode) && strings.EqualFold(strings.TrimSpace(te), "chunked")
+	declared, _ := headers.List("trailer")
+	for _, name := range declared {
+		w.trailers = append(w.trailers, strings.ToLower(name))
+	}
+	if !hasLength && !hasEncoding && bodyAllowed(w.statusCode) {
+		// The body can only be delimited by closing the connection
+		w.close = true
+	}
+
 	b := []byte{}
 	headers.ForEach(func(k, v string) {
+		if w.close && strings.EqualFold(k, "connection") {
+			return
+		}
+		if !framingAllowed(w.statusCode) &&
+			(strings.EqualFold(k, "content-length") || strings.EqualFold(k, "transfer-encoding")) {
+			return
+		}
 		b = fmt.Appendf(b, "%s: %s\r\n", k, v)
 	})
+	if w.close {
+		b = fmt.Appendf(b, "Connection: close\r\n")
+	}
 	b = fmt.Appendf(b, "\r\n")
-	_, err := w.writer.Write(b)
+	w.state = WriteStateBody
+	_, err := w.write(b)
 
 	return err
 }
+
+// WriteBody writes part of the body. A handler that skips straight to the
+// body gets a 200 status line and default headers; since the length isn't
+// known up front the body is then delimited by closing the connection.
+// Writes that would go past a declared Content-Length fail with
+// ErrorBodyTooLong and write nothing, as do writes for a status that allows no
+// body, such as 204, with ErrorBodyNotAllowed.
 func (w *Writer) WriteBody(body []byte) (int, error) {
-	n, err := w.writer.Write(body)
+	if w.state == WriteStateStatusLine {
+		if err := w.WriteStatusLine(StatusOK); err != nil {
+			return 0, err
+		}
+	}
+	if w.state == WriteStateHeaders {
+		h := GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		if err := w.WriteHeaders(*h); err != nil {
+			return 0, err
+		}
+	}
+	if w.chunked {
+		return w.WriteChunkedBody(body)
+	}
+	if err := w.expectState(WriteStateBody, "body"); err != nil {
+		return 0, err
+	}
+	if !bodyAllowed(w.statusCode) && len(body) > 0 {
+		return 0, ErrorBodyNotAllowed
+	}
+
+	if w.contentLength >= 0 && len(body) > w.contentLength-w.bodyBytes {
+		// Anything past the announced length would be read as the start of
+		// the next response
+		w.close = true
+		return 0, ErrorBodyTooLong
+	}
+
+	n, err := w.write(body)
+	w.bodyBytes += n
 	return n, err
 }
 
-// func (w *Writer) WriteChunkedBody(p []byte) (int, error)
+// WriteChunkedBody writes p as a single chunk. The headers must have set
+// "Transfer-Encoding: chunked". It returns the number of bytes of p written.
+func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.expectState(WriteStateBody, "chunk"); err != nil {
+		return 0, err
+	}
+	if !w.chunked {
+		return 0, ErrorNotChunked
+	}
+	// A zero length chunk would end the body
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	chunk := fmt.Appendf(nil, "%x\r\n", len(p))
+	chunk = append(chunk, p...)
+	chunk = append(chunk, "\r\n"...)
+	if _, err := w.write(chunk); err != nil {
+		return 0, err
+	}
 
+	w.bodyBytes += len(p)
+	return len(p), nil
+}
 
-type Response struct {
+// WriteChunkedBodyDone writes the last chunk. If the headers announced
+// trailers they have to follow through WriteTrailers, otherwise the response
+// is complete.
+func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if err := w.expectState(WriteStateBody, "last chunk"); err != nil {
+		return 0, err
+	}
+	if !w.chunked {
+		return 0, ErrorNotChunked
+	}
+
+	last := []byte("0\r\n")
+	if len(w.trailers) == 0 {
+		last = append(last, "\r\n"...)
+		w.state = WriteStateDone
+	} else {
+		w.state = WriteStateTrailer
+	}
+
+	return w.write(last)
 }
 
-type StatusCode int
+// WriteTrailers writes the trailer section after the last chunk. Every field
+// must have been announced in the Trailer header.
+func (w *Writer) WriteTrailers(trailers headers.Headers) error {
+	if err := w.expectState(WriteStateTrailer, "trailers"); err != nil {
+		return err
+	}
 
-const (
-	StatusOK                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-)
+	var err error
+	b := []byte{}
+	trailers.ForEach(func(k, v string) {
+		if !slices.Contains(w.trailers, strings.ToLower(k)) {
+			err = fmt.Errorf("%w: %s", ErrorUndeclaredTrailer, k)
+			return
+		}
+		b = fmt.Appendf(b, "%s: %s\r\n", k, v)
+	})
+	if err != nil {
+		return err
+	}
+	b = fmt.Appendf(b, "\r\n")
+
+	w.state = WriteStateDone
+	_, err = w.write(b)
+	return err
+}
+
+type Response struct {
+}
 
 func GetDefaultHeaders(contentLen int) *headers.Headers {
 	h := headers.NewHeaders()
 	h.Set("Content-Length", fmt.Sprintf("%d", contentLen))
-	h.Set("Connection", "close")
 	h.Set("Content-Type", "text/plain")
 	return h
-}
\ No newline at end of file
+}
@@ -0,0 +1,287 @@
+package response
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/headers"
+)
+
+func TestStatusText(t *testing.T) {
+	assert.Equal(t, "OK", StatusText(StatusOK))
+	assert.Equal(t, "No Content", StatusText(StatusNoContent))
+	assert.Equal(t, "Not Found", StatusText(StatusNotFound))
+	assert.Equal(t, "Too Many Requests", StatusText(StatusTooManyRequests))
+	assert.Equal(t, "Service Unavailable", StatusText(StatusServiceUnavailable))
+	assert.Equal(t, "", StatusText(299))
+}
+
+func TestWriteStatusLine(t *testing.T) {
+	// Test: Registered status codes
+	for code, line := range map[StatusCode]string{
+		StatusOK:               "HTTP/1.1 200 OK\r\n",
+		StatusCreated:          "HTTP/1.1 201 Created\r\n",
+		StatusMovedPermanently: "HTTP/1.1 301 Moved Permanently\r\n",
+		StatusNotModified:      "HTTP/1.1 304 Not Modified\r\n",
+		StatusMethodNotAllowed: "HTTP/1.1 405 Method Not Allowed\r\n",
+	} {
+		buf := &bytes.Buffer{}
+		require.NoError(t, NewWriter(buf).WriteStatusLine(code))
+		assert.Equal(t, line, buf.String())
+	}
+
+	// Test: Unregistered status code has an empty reason phrase
+	buf := &bytes.Buffer{}
+	require.NoError(t, NewWriter(buf).WriteStatusLine(299))
+	assert.Equal(t, "HTTP/1.1 299 \r\n", buf.String())
+
+	// Test: Custom reason phrase
+	buf = &bytes.Buffer{}
+	require.NoError(t, NewWriter(buf).WriteStatusLineWithReason(StatusOK, "Totally Fine"))
+	assert.Equal(t, "HTTP/1.1 200 Totally Fine\r\n", buf.String())
+
+	// Test: Code that isn't three digits
+	buf = &bytes.Buffer{}
+	require.ErrorIs(t, NewWriter(buf).WriteStatusLine(42), ErrorInvalidStatusCode)
+	require.ErrorIs(t, NewWriter(buf).WriteStatusLine(1000), ErrorInvalidStatusCode)
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Reason phrase can't smuggle a header
+	require.ErrorIs(t, NewWriter(buf).WriteStatusLineWithReason(StatusOK, "OK\r\nX-Evil: 1"), ErrorInvalidReasonPhrase)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestWriterState(t *testing.T) {
+	// Test: Writes in order
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	_, err := w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte(""))
+	require.NoError(t, err)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Header fields keep their order, casing and repeats
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Add("Set-Cookie", "a=1")
+	h.Add("set-cookie", "b=2")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	assert.Equal(t, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nContent-Type: text/plain\r\nSet-Cookie: a=1\r\nset-cookie: b=2\r\n\r\n", buf.String())
+
+	// Test: A body shorter than its Content-Length can't be followed by
+	// another response
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(10)))
+	_, err = w.WriteBody([]byte("abc"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+
+	// Test: Bytes past the Content-Length are refused
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(3)))
+	n, err := w.WriteBody([]byte("abcdef"))
+	require.ErrorIs(t, err, ErrorBodyTooLong)
+	assert.Equal(t, 0, n)
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.False(t, w.KeepAlive())
+
+	// Test: A 204 sends neither Content-Length nor Transfer-Encoding and
+	// refuses a body
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = GetDefaultHeaders(0)
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(*h))
+	assert.Equal(t, "HTTP/1.1 204 No Content\r\nContent-Type: text/plain\r\n\r\n", buf.String())
+	n, err = w.WriteBody([]byte("abc"))
+	require.ErrorIs(t, err, ErrorBodyNotAllowed)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteChunkedBody([]byte("abc"))
+	require.ErrorIs(t, err, ErrorNotChunked)
+	assert.True(t, w.KeepAlive())
+
+	// Test: A 1xx response has no framing headers either
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusContinue))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	assert.NotContains(t, buf.String(), "Content-Length")
+
+	// Test: A 304 keeps the Content-Length of the representation
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotModified))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(5)))
+	assert.Contains(t, buf.String(), "Content-Length: 5\r\n")
+	_, err = w.WriteBody([]byte("hello"))
+	require.ErrorIs(t, err, ErrorBodyNotAllowed)
+	assert.True(t, w.KeepAlive())
+
+	// Test: Status line written twice
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.ErrorIs(t, w.WriteStatusLine(StatusNotFound), ErrorWriterState)
+	assert.Equal(t, "HTTP/1.1 200 OK\r\n", buf.String())
+
+	// Test: Headers before the status line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ErrorWriterState)
+	assert.Equal(t, 0, buf.Len())
+
+	// Test: Headers written twice
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(0)))
+	n = buf.Len()
+	require.ErrorIs(t, w.WriteHeaders(*GetDefaultHeaders(0)), ErrorWriterState)
+	require.ErrorIs(t, w.WriteStatusLine(StatusOK), ErrorWriterState)
+	assert.Equal(t, n, buf.Len())
+
+	// Test: Body first emits a default status line and headers
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	_, err = w.WriteBody([]byte("hello"))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "HTTP/1.1 200 OK\r\n")
+	assert.Contains(t, buf.String(), "Connection: close\r\n")
+	assert.Contains(t, buf.String(), "\r\n\r\nhello")
+	assert.False(t, w.KeepAlive())
+
+	// Test: Body after only the status line
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusNotFound))
+	_, err = w.WriteBody([]byte("missing"))
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "HTTP/1.1 404 Not Found\r\n")
+	assert.Contains(t, buf.String(), "\r\n\r\nmissing")
+}
+
+func TestChunkedBody(t *testing.T) {
+	// Test: Chunks with trailers
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	h := GetDefaultHeaders(0)
+	h.Delete("Content-Length")
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	n, err := w.WriteChunkedBody([]byte("hello world!"))
+	require.NoError(t, err)
+	assert.Equal(t, 12, n)
+	n, err = w.WriteChunkedBody([]byte(""))
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	_, err = w.WriteBody([]byte("more"))
+	require.NoError(t, err)
+	assert.False(t, w.KeepAlive())
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers := headers.NewHeaders()
+	trailers.Set("X-Checksum", "abc")
+	require.NoError(t, w.WriteTrailers(*trailers))
+	assert.True(t, w.KeepAlive())
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nc\r\nhello world!\r\n4\r\nmore\r\n0\r\nX-Checksum: abc\r\n\r\n"))
+
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!more", string(body))
+	assert.Equal(t, "abc", res.Trailer.Get("X-Checksum"))
+
+	// Test: No declared trailers ends the response with the last chunk
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.NoError(t, err)
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(buf.String(), "2\r\nhi\r\n0\r\n\r\n"))
+	assert.True(t, w.KeepAlive())
+	require.ErrorIs(t, w.WriteTrailers(*headers.NewHeaders()), ErrorWriterState)
+
+	// Test: Undeclared trailer
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	h = headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	h.Set("Trailer", "X-Checksum")
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	trailers = headers.NewHeaders()
+	trailers.Set("X-Other", "abc")
+	require.ErrorIs(t, w.WriteTrailers(*trailers), ErrorUndeclaredTrailer)
+
+	// Test: Chunks on a response that isn't chunked
+	buf = &bytes.Buffer{}
+	w = NewWriter(buf)
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(2)))
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.ErrorIs(t, err, ErrorNotChunked)
+	_, err = w.WriteChunkedBodyDone()
+	require.ErrorIs(t, err, ErrorNotChunked)
+
+	// Test: Chunks before the headers
+	w = NewWriter(&bytes.Buffer{})
+	_, err = w.WriteChunkedBody([]byte("hi"))
+	require.ErrorIs(t, err, ErrorWriterState)
+}
+
+func TestWriterObservation(t *testing.T) {
+	// Test: Status code and body bytes are tracked
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	assert.Equal(t, StatusCode(0), w.StatusCode())
+	require.NoError(t, w.WriteStatusLine(StatusCreated))
+	h := headers.NewHeaders()
+	h.Set("Transfer-Encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(*h))
+	_, err := w.WriteChunkedBody([]byte("hello"))
+	require.NoError(t, err)
+	_, err = w.WriteBody([]byte(" world"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusCreated, w.StatusCode())
+	assert.Equal(t, 11, w.BytesWritten())
+
+	// Test: Wrap sees every byte sent
+	buf = &bytes.Buffer{}
+	tee := &bytes.Buffer{}
+	w = NewWriter(buf)
+	w.Wrap(func(out io.Writer) io.Writer {
+		return io.MultiWriter(out, tee)
+	})
+	require.NoError(t, w.WriteStatusLine(StatusOK))
+	require.NoError(t, w.WriteHeaders(*GetDefaultHeaders(2)))
+	_, err = w.WriteBody([]byte("hi"))
+	require.NoError(t, err)
+	assert.Equal(t, buf.String(), tee.String())
+	assert.True(t, strings.HasSuffix(tee.String(), "\r\n\r\nhi"))
+}
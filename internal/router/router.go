@@ -0,0 +1,203 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/trial-pyth/httpfromtcp/internal/headers"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+	"github.com/trial-pyth/httpfromtcp/internal/server"
+)
+
+// segmentKind orders the kinds of pattern segments from the least to the
+// most specific, which decides between several matching routes.
+type segmentKind int
+
+const (
+	segmentWildcard segmentKind = iota
+	segmentParam
+	segmentStatic
+)
+
+type segment struct {
+	kind segmentKind
+	// value is the literal text of a static segment or the parameter name
+	value string
+}
+
+type route struct {
+	method   string
+	segments []segment
+	handler  server.Handler
+}
+
+// Router dispatches requests by method and path. Patterns are made of
+// slash-separated segments that are either static text, a named parameter
+// such as {id}, or a trailing wildcard written as * or {name...} that matches
+// the rest of the path. Matched parameters are available through
+// request.Request.PathValue.
+type Router struct {
+	routes []*route
+
+	// ErrorRenderer writes the 404 and 405 responses.
+	// server.TextErrorRenderer if nil.
+	ErrorRenderer server.ErrorRenderer
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// Handle registers handler for method and pattern, wrapped in the given
+// middleware. It panics if the pattern is malformed.
+func (rt *Router) Handle(method, pattern string, handler server.Handler, middleware ...server.Middleware) {
+	rt.routes = append(rt.routes, &route{
+		method:   method,
+		segments: parsePattern(pattern),
+		handler:  server.Chain(middleware...)(handler),
+	})
+}
+
+// Handler returns the server.Handler that dispatches to the registered
+// routes. Unknown paths get a 404 and known paths requested with another
+// method a 405 listing the allowed methods.
+func (rt *Router) Handler() server.Handler {
+	return rt.dispatch
+}
+
+func (rt *Router) dispatch(w *response.Writer, req *request.Request) {
+	path, _, _ := strings.Cut(req.RequestLine.RequestTarget, "?")
+	parts := splitPath(path)
+
+	var best *route
+	var bestValues map[string]string
+	allowed := []string{}
+	for _, r := range rt.routes {
+		values, ok := r.match(parts)
+		if !ok {
+			continue
+		}
+		if r.method != req.RequestLine.Method {
+			if !slices.Contains(allowed, r.method) {
+				allowed = append(allowed, r.method)
+			}
+			continue
+		}
+		if best == nil || r.moreSpecific(best) {
+			best = r
+			bestValues = values
+		}
+	}
+
+	if best == nil {
+		herr := &server.HandlerError{StatusCode: response.StatusNotFound}
+		if len(allowed) > 0 {
+			slices.Sort(allowed)
+			herr.StatusCode = response.StatusMethodNotAllowed
+			herr.Headers = headers.NewHeaders()
+			herr.Headers.Set("Allow", strings.Join(allowed, ", "))
+		}
+		herr.Message = response.StatusText(herr.StatusCode)
+		rt.renderError(w, req, herr)
+		return
+	}
+
+	for name, value := range bestValues {
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		req.SetPathValue(name, value)
+	}
+	best.handler(w, req)
+}
+
+func (rt *Router) renderError(w *response.Writer, req *request.Request, herr *server.HandlerError) {
+	render := rt.ErrorRenderer
+	if render == nil {
+		render = server.TextErrorRenderer
+	}
+	render(w, req, herr)
+}
+
+// splitPath breaks a path into its segments, a trailing slash doesn't count
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+func parsePattern(pattern string) []segment {
+	if !strings.HasPrefix(pattern, "/") {
+		panic(fmt.Sprintf("router: pattern %q must start with /", pattern))
+	}
+
+	parts := splitPath(pattern)
+	segments := make([]segment, 0, len(parts))
+	for i, part := range parts {
+		seg := segment{kind: segmentStatic, value: part}
+		if part == "*" {
+			seg = segment{kind: segmentWildcard, value: "*"}
+		} else if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			name := part[1 : len(part)-1]
+			seg.kind = segmentParam
+			if rest, ok := strings.CutSuffix(name, "..."); ok {
+				name = rest
+				seg.kind = segmentWildcard
+			}
+			if name == "" {
+				panic(fmt.Sprintf("router: pattern %q has an unnamed parameter", pattern))
+			}
+			seg.value = name
+		}
+
+		if seg.kind == segmentWildcard && i != len(parts)-1 {
+			panic(fmt.Sprintf("router: wildcard must be the last segment of pattern %q", pattern))
+		}
+		segments = append(segments, seg)
+	}
+
+	return segments
+}
+
+// match reports whether the route matches the path segments and returns the
+// parameters it captured
+func (r *route) match(parts []string) (map[string]string, bool) {
+	values := map[string]string{}
+	for i, seg := range r.segments {
+		if seg.kind == segmentWildcard {
+			values[seg.value] = strings.Join(parts[i:], "/")
+			return values, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+
+		switch seg.kind {
+		case segmentStatic:
+			if parts[i] != seg.value {
+				return nil, false
+			}
+		case segmentParam:
+			if parts[i] == "" {
+				return nil, false
+			}
+			values[seg.value] = parts[i]
+		}
+	}
+
+	return values, len(parts) == len(r.segments)
+}
+
+// moreSpecific reports whether r should win over other when both match
+func (r *route) moreSpecific(other *route) bool {
+	for i := 0; i < len(r.segments) && i < len(other.segments); i++ {
+		if r.segments[i].kind != other.segments[i].kind {
+			return r.segments[i].kind > other.segments[i].kind
+		}
+	}
+
+	// Routes matching the same path only differ in length by a trailing
+	// wildcard that matched nothing, so the exact route is the shorter one
+	return len(r.segments) < len(other.segments)
+}
@@ -0,0 +1,147 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+	"github.com/trial-pyth/httpfromtcp/internal/server"
+)
+
+// named responds with its own name followed by the given path values
+func named(name string, params ...string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		out := name
+		for _, p := range params {
+			out += " " + p + "=" + req.PathValue(p)
+		}
+		body := []byte(out)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func do(t *testing.T, h server.Handler, method, target string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+
+	res, err := http.ReadResponse(bufio.NewReader(buf), nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+
+	return res, string(body)
+}
+
+func TestRouter(t *testing.T) {
+	rt := New()
+	rt.Handle("GET", "/", named("root"))
+	rt.Handle("GET", "/users", named("list"))
+	rt.Handle("POST", "/users", named("create"))
+	rt.Handle("GET", "/users/me", named("me"))
+	rt.Handle("GET", "/users/{id}", named("user", "id"))
+	rt.Handle("DELETE", "/users/{id}", named("delete", "id"))
+	rt.Handle("GET", "/users/{id}/posts/{post}", named("post", "id", "post"))
+	rt.Handle("GET", "/static/*", named("static", "*"))
+	rt.Handle("GET", "/files/{path...}", named("files", "path"))
+	rt.Handle("GET", "/files/readme", named("readme"))
+	rt.Handle("GET", "/files", named("index"))
+	rt.Handle("GET", "/docs/{path...}", named("docs", "path"))
+	h := rt.Handler()
+
+	cases := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{"GET", "/", "root"},
+		{"GET", "/users", "list"},
+		{"GET", "/users/", "list"},
+		{"POST", "/users", "create"},
+		{"GET", "/users/me", "me"},
+		{"GET", "/users/42", "user id=42"},
+		{"GET", "/users/42?full=true", "user id=42"},
+		{"GET", "/users/john%20doe", "user id=john doe"},
+		{"DELETE", "/users/42", "delete id=42"},
+		{"GET", "/users/42/posts/7", "post id=42 post=7"},
+		{"GET", "/static/css/site.css", "static *=css/site.css"},
+		{"GET", "/files/a/b/c.txt", "files path=a/b/c.txt"},
+		{"GET", "/files/readme", "readme"},
+		{"GET", "/files", "index"},
+		{"GET", "/files/", "index"},
+		{"GET", "/docs", "docs path="},
+	}
+	for _, c := range cases {
+		res, body := do(t, h, c.method, c.target)
+		assert.Equal(t, 200, res.StatusCode, c.target)
+		assert.Equal(t, c.body, body, c.target)
+	}
+
+	// Test: Unknown path
+	res, _ := do(t, h, "GET", "/nope")
+	assert.Equal(t, 404, res.StatusCode)
+	res, _ = do(t, h, "GET", "/users/42/comments")
+	assert.Equal(t, 404, res.StatusCode)
+
+	// Test: Wrong method lists the allowed ones
+	res, _ = do(t, h, "PUT", "/users/42")
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "DELETE, GET", res.Header.Get("Allow"))
+	res, _ = do(t, h, "DELETE", "/users")
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "GET, POST", res.Header.Get("Allow"))
+
+	// Test: Errors go through the configured renderer
+	rt.ErrorRenderer = server.ProblemErrorRenderer
+	res, body := do(t, h, "GET", "/nope")
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	assert.Contains(t, body, `"status":404`)
+	res, _ = do(t, h, "PUT", "/users/42")
+	assert.Equal(t, 405, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	assert.Equal(t, "DELETE, GET", res.Header.Get("Allow"))
+}
+
+func TestRouteMiddleware(t *testing.T) {
+	// Test: Middleware only wraps its own route
+	deny := func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if _, ok := req.Headers.Get("Authorization"); !ok {
+				server.TextErrorRenderer(w, req, &server.HandlerError{StatusCode: response.StatusUnauthorized, Message: "Unauthorized"})
+				return
+			}
+			next(w, req)
+		}
+	}
+	rt := New()
+	rt.Handle("GET", "/public", named("public"))
+	rt.Handle("GET", "/private", named("private"), deny)
+	h := rt.Handler()
+
+	res, body := do(t, h, "GET", "/public")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "public", body)
+	res, _ = do(t, h, "GET", "/private")
+	assert.Equal(t, 401, res.StatusCode)
+}
+
+func TestInvalidPatterns(t *testing.T) {
+	rt := New()
+	assert.Panics(t, func() { rt.Handle("GET", "users", named("x")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/users/{}", named("x")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/static/*/more", named("x")) })
+	assert.Panics(t, func() { rt.Handle("GET", "/files/{path...}/more", named("x")) })
+}
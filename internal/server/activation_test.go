@@ -0,0 +1,57 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestActivationListeners(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer tcp.Close()
+	file, err := tcp.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+
+	// Test: Not socket activated
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	listeners, err := activationListeners(int(file.Fd()))
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Test: Sockets passed to another process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, err = activationListeners(int(file.Fd()))
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Test: Sockets passed to this process
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	// activationListeners takes ownership of the descriptors it is given
+	fd, err := syscall.Dup(int(file.Fd()))
+	require.NoError(t, err)
+	listeners, err = activationListeners(fd)
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Equal(t, tcp.Addr().String(), listeners[0].Addr().String())
+	listeners[0].Close()
+	_, ok := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, ok)
+
+	// Test: Malformed count
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "many")
+	_, err = activationListeners(int(file.Fd()))
+	assert.Error(t, err)
+}
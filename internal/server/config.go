@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"time"
+
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+)
+
+// Config describes a Server. A zero timeout means no timeout.
+type Config struct {
+	// Network is the network ListenAndServe listens on, "tcp" if empty. With
+	// "unix" Addr is the socket path, or an abstract socket name when it
+	// starts with "@".
+	Network string
+	// Addr is the address ListenAndServe listens on, ":http" if empty. Port
+	// 0 picks a free port, see Server.Addr.
+	Addr string
+	// Handler serves every request, wrapped in Middleware
+	Handler    Handler
+	Middleware []Middleware
+
+	// ReadHeaderTimeout bounds reading the request line and headers, counted
+	// from the first byte of the request. Falls back to ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request including its body
+	ReadTimeout time.Duration
+	// WriteTimeout bounds writing the response, counted from the end of the
+	// request headers
+	WriteTimeout time.Duration
+	// IdleTimeout bounds the wait for the next request on a persistent
+	// connection. Falls back to ReadTimeout.
+	IdleTimeout time.Duration
+
+	// TLSConfig configures ServeTLS and ListenAndServeTLS, which add the
+	// certificate files they are given
+	TLSConfig *tls.Config
+
+	// Limits bounds the size of requests. Zero fields take their value from
+	// request.DefaultLimits, a negative field turns that limit off.
+	Limits request.Limits
+	// Logger receives server errors such as recovered panics, the standard
+	// logger if nil
+	Logger *log.Logger
+	// ErrorRenderer writes the responses for errors the server detects
+	// itself, such as malformed requests. TextErrorRenderer if nil.
+	ErrorRenderer ErrorRenderer
+	// MaxConns caps the number of connections served at once. Further
+	// connections wait in the listen backlog, or are turned away with
+	// RejectOverLimit. Zero means no cap.
+	MaxConns int
+	// RejectOverLimit answers connections over MaxConns with 503 Service
+	// Unavailable and closes them instead of letting them wait
+	RejectOverLimit bool
+}
+
+// DefaultConfig holds the timeouts used by the httpserver, a starting point
+// for callers that want some protection against slow clients.
+var DefaultConfig = Config{
+	ReadHeaderTimeout: 10 * time.Second,
+	IdleTimeout:       60 * time.Second,
+}
+
+// deadline turns a timeout into a deadline counted from start, or the zero
+// time if there is no timeout
+func deadline(start time.Time, timeouts ...time.Duration) time.Time {
+	for _, timeout := range timeouts {
+		if timeout > 0 {
+			return start.Add(timeout)
+		}
+	}
+	return time.Time{}
+}
+
+// connReader applies the read timeouts of config to conn. It waits for a
+// request under the idle timeout and switches to the header timeout as soon
+// as the first byte of the request arrives.
+type connReader struct {
+	conn   net.Conn
+	config Config
+	// waiting is set until the first byte of the current request is read
+	waiting bool
+	started time.Time
+}
+
+func (r *connReader) Read(p []byte) (int, error) {
+	n, err := r.conn.Read(p)
+	if n > 0 && r.waiting {
+		r.waiting = false
+		r.started = time.Now()
+		r.conn.SetReadDeadline(deadline(r.started, r.config.ReadHeaderTimeout, r.config.ReadTimeout))
+	}
+	return n, err
+}
+
+// awaitRequest arms the idle timeout before reading the next request
+func (r *connReader) awaitRequest() {
+	r.waiting = true
+	r.started = time.Now()
+	r.conn.SetReadDeadline(deadline(r.started, r.config.IdleTimeout, r.config.ReadTimeout))
+	r.conn.SetWriteDeadline(time.Time{})
+}
+
+// startBody leaves what is left of ReadTimeout for the body once the headers
+// are in, and starts the WriteTimeout for the response
+func (r *connReader) startBody() {
+	if r.waiting {
+		// The request was already buffered, it starts now
+		r.waiting = false
+		r.started = time.Now()
+	}
+	r.conn.SetReadDeadline(deadline(r.started, r.config.ReadTimeout))
+	r.conn.SetWriteDeadline(deadline(time.Now(), r.config.WriteTimeout))
+}
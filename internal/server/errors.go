@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+)
+
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d %s", e.StatusCode, e.Message)
+}
+
+// ErrorHandler is a handler that reports failure by returning a
+// HandlerError instead of writing the error response itself.
+type ErrorHandler func(w *response.Writer, req *request.Request) *HandlerError
+
+// ErrorRenderer turns a HandlerError into a complete response
+type ErrorRenderer func(w *response.Writer, req *request.Request, herr *HandlerError)
+
+// WithErrors adapts an ErrorHandler to a Handler, rendering returned errors
+// with render, or as plain text if render is nil. Errors returned after the
+// handler started its own response can't be rendered and are dropped.
+func WithErrors(handler ErrorHandler, render ErrorRenderer) Handler {
+	if render == nil {
+		render = TextErrorRenderer
+	}
+
+	return func(w *response.Writer, req *request.Request) {
+		herr := handler(w, req)
+		if herr == nil || w.State() != response.WriteStateStatusLine {
+			return
+		}
+		render(w, req, herr)
+	}
+}
+
+func writeError(w *response.Writer, herr *HandlerError, contentType string, body []byte) {
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("Content-Type", contentType)
+	if herr.Headers != nil {
+		herr.Headers.ForEach(func(k, v string) {
+			h.Add(k, v)
+		})
+	}
+	w.WriteStatusLine(herr.StatusCode)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+// TextErrorRenderer writes the error message as plain text
+func TextErrorRenderer(w *response.Writer, req *request.Request, herr *HandlerError) {
+	writeError(w, herr, "text/plain", []byte(herr.Message+"\n"))
+}
+
+// HTMLErrorRenderer writes a small HTML page with the status and message
+func HTMLErrorRenderer(w *response.Writer, req *request.Request, herr *HandlerError) {
+	title := fmt.Sprintf("%d %s", herr.StatusCode, response.StatusText(herr.StatusCode))
+	body := fmt.Appendf(nil, `<html>
+  <head>
+    <title>%s</title>
+  </head>
+  <body>
+    <h1>%s</h1>
+    <p>%s</p>
+  </body>
+</html>
+`, html.EscapeString(title), html.EscapeString(title), html.EscapeString(herr.Message))
+	writeError(w, herr, "text/html", body)
+}
+
+// problem is a problem details object as defined by RFC 9457
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// ProblemErrorRenderer writes the error as RFC 9457 problem details in JSON.
+// Errors the server detects before a request was parsed have no instance.
+func ProblemErrorRenderer(w *response.Writer, req *request.Request, herr *HandlerError) {
+	p := problem{
+		Type:   "about:blank",
+		Title:  response.StatusText(herr.StatusCode),
+		Status: int(herr.StatusCode),
+		Detail: herr.Message,
+	}
+	if req != nil {
+		p.Instance = req.RequestLine.RequestTarget
+	}
+	body, _ := json.Marshal(p)
+	writeError(w, herr, "application/problem+json", body)
+}
@@ -0,0 +1,16 @@
+package server
+
+// Middleware wraps a Handler with behaviour that runs around it, such as
+// logging, authentication or recovery.
+type Middleware func(Handler) Handler
+
+// Chain combines middleware into one. The first middleware is the outermost,
+// so it sees the request first and the response last.
+func Chain(middleware ...Middleware) Middleware {
+	return func(handler Handler) Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			handler = middleware[i](handler)
+		}
+		return handler
+	}
+}
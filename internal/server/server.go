@@ -1,74 +1,518 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/trial-pyth/httpfromtcp/internal/headers"
 	"github.com/trial-pyth/httpfromtcp/internal/request"
 	"github.com/trial-pyth/httpfromtcp/internal/response"
 )
 
+var ErrServerClosed = fmt.Errorf("server closed")
+
+// rejectTimeout bounds the time spent turning away a connection over
+// MaxConns
+const rejectTimeout = time.Second
+
+// maxRejecting bounds how many connections are turned away with a 503 at
+// once, further ones are closed right away
+const maxRejecting = 16
+
+// lingerTimeout bounds the wait for the client to stop sending before a
+// connection with unread data is closed
+const lingerTimeout = 500 * time.Millisecond
+
 type Server struct {
-	closed  bool
+	closed  atomic.Bool
 	handler Handler
+	config  Config
+	limits  request.Limits
+	logger  *log.Logger
+	render  ErrorRenderer
+	// slots holds one token per connection being served when MaxConns is set
+	slots chan struct{}
+	// rejecting holds one token per connection being turned away with a 503
+	rejecting chan struct{}
+
+	listeners []net.Listener
+	// conns maps every open connection to whether it is idle, i.e. waiting
+	// for its next request rather than serving one
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
 }
 
 type HandlerError struct {
 	StatusCode response.StatusCode
 	Message    string
+	// Headers are extra fields the error response must carry, such as Allow
+	// on a 405. May be nil.
+	Headers *headers.Headers
 }
 
 type Handler func(w *response.Writer, req *request.Request)
 
-func runConnection(s *Server, conn io.ReadWriteCloser) {
+// New creates a Server from config. Nothing is started until Serve or
+// ListenAndServe is called.
+func New(config Config) *Server {
+	s := &Server{
+		handler: Chain(config.Middleware...)(config.Handler),
+		config:  config,
+		limits:  withDefaultLimits(config.Limits),
+		logger:  config.Logger,
+		render:  config.ErrorRenderer,
+		conns:   map[net.Conn]bool{},
+	}
+	if s.logger == nil {
+		s.logger = log.Default()
+	}
+	if s.render == nil {
+		s.render = TextErrorRenderer
+	}
+	if config.MaxConns > 0 {
+		s.slots = make(chan struct{}, config.MaxConns)
+		s.rejecting = make(chan struct{}, maxRejecting)
+	}
+
+	return s
+}
+
+// writeServerError answers with an error the server detected itself and
+// closes the connection afterwards
+func (s *Server) writeServerError(w *response.Writer, req *request.Request, status response.StatusCode) {
+	w.SetConnectionClose()
+	s.render(w, req, &HandlerError{StatusCode: status, Message: response.StatusText(status)})
+}
+
+func runConnection(s *Server, conn net.Conn) {
 	defer conn.Close()
 
-	responseWriter := response.NewWriter(conn)
-	r, err := request.RequestFromReader(conn)
-	if err != nil {
-		responseWriter.WriteStatusLine(response.StatusBadRequest)
-		responseWriter.WriteHeaders(*response.GetDefaultHeaders(0))
-		return
+	// A panic only takes down its own connection. If nothing has been sent
+	// yet the client still gets a 500, either way the connection is closed
+	// since its state is unknown.
+	var responseWriter *response.Writer
+	defer func() {
+		if v := recover(); v != nil {
+			s.logger.Printf("panic serving %s: %v\n%s", conn.RemoteAddr(), v, debug.Stack())
+			if responseWriter != nil && responseWriter.State() == response.WriteStateStatusLine {
+				s.writeServerError(responseWriter, nil, response.StatusInternalServerError)
+			}
+		}
+	}()
+
+	tlsConn, isTLS := conn.(*tls.Conn)
+	if isTLS {
+		// Handshake up front so a failed handshake isn't mistaken for a
+		// malformed request
+		conn.SetDeadline(deadline(time.Now(), s.config.ReadHeaderTimeout, s.config.ReadTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			s.logger.Printf("TLS handshake error from %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+
+	// Requests are handled one at a time, so pipelined requests are always
+	// answered in the order they arrived
+	reader := &connReader{conn: conn, config: s.config}
+	parser := request.NewParserWithLimits(reader, s.limits)
+	for {
+		if !s.setIdle(conn, true) {
+			return
+		}
+		reader.awaitRequest()
+
+		responseWriter = response.NewWriter(conn)
+		r, err := parser.Next()
+		if err != nil {
+			// The client went away or stayed idle too long, nothing to answer
+			var netErr net.Error
+			timeout := errors.As(err, &netErr) && netErr.Timeout()
+			if errors.Is(err, io.EOF) || (timeout && reader.waiting) {
+				return
+			}
+
+			status := parseErrorStatus(err)
+			if timeout {
+				// The read deadline has passed, the response still has to go out
+				conn.SetReadDeadline(time.Time{})
+				status = response.StatusRequestTimeout
+			}
+			s.writeServerError(responseWriter, nil, status)
+			return
+		}
+		r.RemoteAddr = conn.RemoteAddr()
+		if isTLS {
+			state := tlsConn.ConnectionState()
+			r.TLS = &state
+		}
+		reader.startBody()
+		s.setIdle(conn, false)
+
+		if !r.KeepAlive() || s.closed.Load() {
+			responseWriter.SetConnectionClose()
+		}
+		// Reading a large body the handler ignored just to reuse the
+		// connection isn't worth it
+		responseWriter.BeforeHeaders(func() {
+			if !r.CanDiscardBody() {
+				responseWriter.SetConnectionClose()
+			}
+		})
+
+		s.handler(responseWriter, r)
+		finishResponse(responseWriter)
+
+		if !responseWriter.KeepAlive() {
+			if !r.CanDiscardBody() {
+				lingerClose(conn)
+			}
+			return
+		}
+
+		// The next request starts after this body, which the handler may
+		// not have read
+		if err := r.DiscardBody(); err != nil {
+			if errors.Is(err, request.ErrorBodyNotDrained) {
+				lingerClose(conn)
+			}
+			return
+		}
+	}
+}
+
+// withDefaultLimits fills every zero field of limits from
+// request.DefaultLimits, so setting one limit doesn't lift the others
+func withDefaultLimits(limits request.Limits) request.Limits {
+	fill := func(limit *int, fallback int) {
+		if *limit == 0 {
+			*limit = fallback
+		}
+	}
+	fill(&limits.MaxRequestLine, request.DefaultLimits.MaxRequestLine)
+	fill(&limits.MaxHeaderBytes, request.DefaultLimits.MaxHeaderBytes)
+	fill(&limits.MaxHeaderCount, request.DefaultLimits.MaxHeaderCount)
+	fill(&limits.MaxBody, request.DefaultLimits.MaxBody)
+	return limits
+}
+
+// finishResponse completes a response the handler left without headers,
+// with a 200 status line if it wrote nothing at all and an empty body, so
+// the client isn't left without a reply.
+func finishResponse(w *response.Writer) {
+	if w.State() == response.WriteStateStatusLine {
+		w.WriteStatusLine(response.StatusOK)
+	}
+	if w.State() == response.WriteStateHeaders {
+		w.WriteHeaders(*response.GetDefaultHeaders(0))
+	}
+}
+
+// parseErrorStatus picks the status code reported for a request the parser
+// rejected
+func parseErrorStatus(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, request.ErrorRequestLineTooLong):
+		return response.StatusURITooLong
+	case errors.Is(err, request.ErrorHeadersTooLarge):
+		return response.StatusRequestHeaderFieldsTooLarge
+	case errors.Is(err, request.ErrorBodyTooLarge):
+		return response.StatusContentTooLarge
+	case errors.Is(err, request.ErrorUnsupportedTransferCoding):
+		return response.StatusNotImplemented
+	default:
+		return response.StatusBadRequest
+	}
+}
+
+// trackConn registers a new connection, it fails once the server is closed
+func (s *Server) trackConn(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = map[net.Conn]bool{}
+	}
+	s.conns[conn] = false
+	s.wg.Add(1)
+
+	return true
+}
+
+func (s *Server) untrackConn(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.conns[conn]; ok {
+		delete(s.conns, conn)
+		s.wg.Done()
 	}
+}
+
+// setIdle records whether a connection is between requests. A connection
+// going idle on a closed server reports false and should be shut.
+func (s *Server) setIdle(conn net.Conn, idle bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if idle && s.closed.Load() {
+		return false
+	}
+	if _, ok := s.conns[conn]; ok {
+		s.conns[conn] = idle
+	}
+
+	return true
+}
 
-	s.handler(responseWriter, r)
+// closeConns closes the tracked connections, or only the idle ones
+func (s *Server) closeConns(onlyIdle bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	for conn, idle := range s.conns {
+		if idle || !onlyIdle {
+			conn.Close()
+		}
+	}
 }
 
-func runServer(s *Server, listener net.Listener) {
+// Serve accepts connections on listener until the server is closed, which
+// makes it return ErrServerClosed. The listener is closed on return. Any
+// net.Listener works, such as TCP and Unix sockets or the ones from
+// ActivationListeners, and Serve may be called for several listeners at once.
+func (s *Server) Serve(listener net.Listener) error {
+	if !s.trackListener(listener) {
+		listener.Close()
+		return ErrServerClosed
+	}
+	defer s.wg.Done()
+	defer s.untrackListener(listener)
 
+	var backoff time.Duration
 	for {
+		// Over the cap either wait for a free slot before accepting, which
+		// leaves new connections in the backlog, or accept and reject them
+		queued := s.slots != nil && !s.config.RejectOverLimit
+		if queued {
+			s.slots <- struct{}{}
+		}
+
 		conn, err := listener.Accept()
+		if err != nil {
+			if queued {
+				s.releaseSlot()
+			}
+			if s.closed.Load() {
+				return ErrServerClosed
+			}
+			// Running out of file descriptors and the like can pass, keep
+			// the server up and retry with growing delays
+			if temporary(err) {
+				backoff = min(max(2*backoff, 5*time.Millisecond), time.Second)
+				s.logger.Printf("accept error: %v; retrying in %v", err, backoff)
+				time.Sleep(backoff)
+				continue
+			}
+			return err
+		}
+		backoff = 0
 
-		if s.closed {
-			return
+		if s.slots != nil && !queued {
+			select {
+			case s.slots <- struct{}{}:
+			default:
+				s.rejectConn(conn)
+				continue
+			}
 		}
-		if err != nil {
-			return
+
+		if !s.trackConn(conn) {
+			s.releaseSlot()
+			conn.Close()
+			return ErrServerClosed
 		}
 
-		go runConnection(s, conn)
+		go func() {
+			defer s.releaseSlot()
+			defer s.untrackConn(conn)
+			runConnection(s, conn)
+		}()
+	}
+}
+
+// trackListener registers a listener being served, it fails once the server
+// is closed
+func (s *Server) trackListener(listener net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		return false
 	}
+	s.listeners = append(s.listeners, listener)
+	s.wg.Add(1)
 
+	return true
 }
 
-func Serve(port uint16, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// untrackListener closes a listener that is no longer served
+func (s *Server) untrackListener(listener net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if i := slices.Index(s.listeners, listener); i >= 0 {
+		s.listeners = slices.Delete(s.listeners, i, i+1)
+		listener.Close()
+	}
+}
+
+// temporary reports whether a failed Accept is worth retrying
+func temporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// rejectConn answers a connection over MaxConns with 503 and closes it.
+// When too many are being rejected already it is closed without an answer,
+// so a burst can't pile up goroutines and file descriptors.
+func (s *Server) rejectConn(conn net.Conn) {
+	select {
+	case s.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() { <-s.rejecting }()
+		defer conn.Close()
+
+		conn.SetDeadline(time.Now().Add(rejectTimeout))
+		s.writeServerError(response.NewWriter(conn), nil, response.StatusServiceUnavailable)
+
+		lingerClose(conn)
+	}()
+}
+
+// lingerClose finishes sending and reads for a moment before the connection
+// is closed. Closing with request data unread resets the connection, which
+// can discard the response before the client has read it.
+func lingerClose(conn net.Conn) {
+	cw, ok := conn.(interface{ CloseWrite() error })
+	if !ok {
+		return
+	}
+	cw.CloseWrite()
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, conn)
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// ListenAndServe listens on the configured network and address and serves
+// it, see Serve.
+func (s *Server) ListenAndServe() error {
+	listener, err := s.listen(":http")
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	return s.Serve(listener)
+}
+
+// listen listens on the configured network and address, using defaultAddr
+// for TCP if no address is set
+func (s *Server) listen(defaultAddr string) (net.Listener, error) {
+	network := s.config.Network
+	if network == "" {
+		network = "tcp"
 	}
+	addr := s.config.Addr
+	if addr == "" && network == "tcp" {
+		addr = defaultAddr
+	}
+
+	return net.Listen(network, addr)
+}
+
+// Addr returns the address the server is listening on, or nil before it has
+// started. With port 0 in Config.Addr this is where the real port shows up.
+// When serving several listeners it is the address of the first.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// stopListening marks the server closed and closes its listeners
+func (s *Server) stopListening() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	server := &Server{
-		closed:  false,
-		handler: handler,
+	s.closed.Store(true)
+	var errs []error
+	for _, listener := range s.listeners {
+		errs = append(errs, listener.Close())
 	}
-	go runServer(server, listener)
+	s.listeners = nil
 
-	return server, err
+	return errors.Join(errs...)
 }
 
+// Close stops the listener and closes every connection immediately,
+// including those still serving a request.
 func (s *Server) Close() error {
-	s.closed = true
-	return nil
+	err := s.stopListening()
+	s.closeConns(false)
+
+	return err
+}
+
+// Shutdown stops accepting connections, closes idle ones and waits for the
+// others to finish their current request. If ctx expires first the remaining
+// connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.stopListening()
+
+	// Busy connections close themselves once their request is answered,
+	// only the ones already waiting for a request need a push
+	s.closeConns(true)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		s.closeConns(false)
+		return ctx.Err()
+	}
 }
@@ -0,0 +1,840 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+)
+
+// startConnection runs a single server connection over an in-memory pipe and
+// returns the client end of it
+func startConnection(t *testing.T, s *Server) (net.Conn, <-chan struct{}) {
+	t.Helper()
+	client, conn := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runConnection(s, conn)
+	}()
+	t.Cleanup(func() { client.Close() })
+
+	return client, done
+}
+
+func echoTargetHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RequestLine.RequestTarget)
+	h := response.GetDefaultHeaders(len(body))
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*h)
+	w.WriteBody(body)
+}
+
+func readResponse(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+	t.Helper()
+	res, err := http.ReadResponse(br, nil)
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+
+	return res, string(body)
+}
+
+func TestKeepAlive(t *testing.T) {
+	// Test: Several requests on one connection
+	s := New(Config{Handler: echoTargetHandler, IdleTimeout: time.Second})
+	client, done := startConnection(t, s)
+	br := bufio.NewReader(client)
+	for _, target := range []string{"/one", "/two", "/three"} {
+		_, err := client.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		res, body := readResponse(t, br)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.False(t, res.Close)
+		assert.Equal(t, target, body)
+	}
+	client.Close()
+	<-done
+
+	// Test: Connection: close from the client
+	client, done = startConnection(t, s)
+	br = bufio.NewReader(client)
+	_, err := client.Write([]byte("GET /bye HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, body := readResponse(t, br)
+	assert.True(t, res.Close)
+	assert.Equal(t, "/bye", body)
+	<-done
+
+	// Test: Idle connections are closed after the timeout
+	s = New(Config{Handler: echoTargetHandler, IdleTimeout: 50 * time.Millisecond})
+	_, done = startConnection(t, s)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+
+	// Test: Responses without framing close the connection
+	s = New(Config{Handler: func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		h := response.GetDefaultHeaders(0)
+		h.Delete("Content-Length")
+		w.WriteHeaders(*h)
+		w.WriteBody([]byte("until close"))
+	}, IdleTimeout: time.Second})
+	client, done = startConnection(t, s)
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.Contains(t, string(raw), "Connection: close\r\n")
+	assert.Contains(t, string(raw), "until close")
+	<-done
+
+	// Test: A handler that writes nothing gets an empty 200 and the
+	// connection stays open
+	s = New(Config{Handler: func(w *response.Writer, req *request.Request) {}, IdleTimeout: time.Second})
+	client, done = startConnection(t, s)
+	br = bufio.NewReader(client)
+	for range 2 {
+		_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		res, body = readResponse(t, br)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, int64(0), res.ContentLength)
+		assert.False(t, res.Close)
+		assert.Empty(t, body)
+	}
+	client.Close()
+	<-done
+}
+
+func TestPipelining(t *testing.T) {
+	// Test: Pipelined requests are answered in order
+	s := New(Config{Handler: echoTargetHandler, IdleTimeout: time.Second})
+	client, done := startConnection(t, s)
+	go client.Write([]byte(
+		"GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"POST /two HTTP/1.1\r\nHost: localhost\r\nContent-Length: 4\r\n\r\nbody" +
+			"GET /three HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n",
+	))
+	br := bufio.NewReader(client)
+	for _, target := range []string{"/one", "/two", "/three"} {
+		res, body := readResponse(t, br)
+		assert.Equal(t, 200, res.StatusCode)
+		assert.Equal(t, target, body)
+	}
+	<-done
+
+	// Test: A body cut short of its Content-Length closes the connection
+	// instead of running into the next response
+	s = New(Config{Handler: func(w *response.Writer, req *request.Request) {
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(10))
+		w.WriteBody([]byte("abc"))
+	}, IdleTimeout: time.Second})
+	client, done = startConnection(t, s)
+	go client.Write([]byte(
+		"GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n" +
+			"GET /two HTTP/1.1\r\nHost: localhost\r\n\r\n",
+	))
+	rest, _ := io.ReadAll(client)
+	assert.True(t, strings.HasSuffix(string(rest), "\r\n\r\nabc"))
+	assert.Equal(t, 1, strings.Count(string(rest), "HTTP/1.1"))
+	<-done
+
+	// Test: A large body the handler ignores isn't drained, the connection
+	// is closed instead
+	ts := startServer(t, Config{Handler: echoTargetHandler})
+	defer ts.Close()
+	conn, err := net.Dial("tcp", ts.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	large := strings.Repeat("a", 1<<20)
+	go conn.Write([]byte("POST /large HTTP/1.1\r\nHost: localhost\r\nContent-Length: " + strconv.Itoa(len(large)) + "\r\n\r\n" + large +
+		"GET /next HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	br = bufio.NewReader(conn)
+	res, body := readResponse(t, br)
+	assert.Equal(t, "/large", body)
+	assert.True(t, res.Close)
+	rest, err = io.ReadAll(br)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+}
+
+func TestParseErrorResponses(t *testing.T) {
+	s := New(Config{
+		Handler:     echoTargetHandler,
+		IdleTimeout: time.Second,
+		Limits: request.Limits{
+			MaxRequestLine: 64,
+			MaxHeaderBytes: 128,
+			MaxBody:        16,
+		},
+	})
+
+	cases := []struct {
+		name   string
+		data   string
+		status int
+	}{
+		{"malformed", "GARBAGE\r\n\r\n", 400},
+		{"missing host", "GET / HTTP/1.1\r\n\r\n", 400},
+		{"duplicate host", "GET / HTTP/1.1\r\nHost: a.example\r\nHost: b.example\r\n\r\n", 400},
+		{"obs-fold", "GET / HTTP/1.1\r\nHost: localhost\r\nX-Test: a\r\n b\r\n\r\n", 400},
+		{"control character", "GET / HTTP/1.1\r\nHost: local\x00host\r\n\r\n", 400},
+		{"long target", "GET /" + strings.Repeat("a", 100) + " HTTP/1.1\r\n\r\n", 414},
+		{"large headers", "GET / HTTP/1.1\r\nX-Big: " + strings.Repeat("b", 200) + "\r\n\r\n", 431},
+		{"large body", "POST / HTTP/1.1\r\nContent-Length: 100\r\n\r\n", 413},
+		{"unknown transfer coding", "POST / HTTP/1.1\r\nTransfer-Encoding: gzip\r\n\r\n", 501},
+	}
+	for _, c := range cases {
+		client, done := startConnection(t, s)
+		go client.Write([]byte(c.data))
+		res, _ := readResponse(t, bufio.NewReader(client))
+		assert.Equal(t, c.status, res.StatusCode, c.name)
+		assert.True(t, res.Close, c.name)
+		<-done
+	}
+
+	// Test: Limits left out of the config keep their defaults
+	s = New(Config{Handler: echoTargetHandler, Limits: request.Limits{MaxBody: 16}})
+	client, done := startConnection(t, s)
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nX-Big: " + strings.Repeat("b", 200<<10) + "\r\n\r\n"))
+	res, _ := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 431, res.StatusCode)
+	<-done
+
+	// Test: A negative limit turns it off
+	s = New(Config{Handler: echoTargetHandler, Limits: request.Limits{MaxHeaderBytes: -1}})
+	client, done = startConnection(t, s)
+	go client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\nX-Big: " + strings.Repeat("b", 200<<10) + "\r\n\r\n"))
+	res, _ = readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 200, res.StatusCode)
+	<-done
+
+	// The configured renderer is used for errors without a request
+	s = New(Config{Handler: echoTargetHandler, ErrorRenderer: ProblemErrorRenderer})
+	client, done = startConnection(t, s)
+	go client.Write([]byte("GARBAGE\r\n\r\n"))
+	res, body := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 400, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	assert.JSONEq(t, `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Bad Request"}`, body)
+	<-done
+}
+
+func TestSmuggledRequest(t *testing.T) {
+	// Test: A CL.TE payload is refused before the hidden request is seen
+	seen := []string{}
+	s := New(Config{Handler: func(w *response.Writer, req *request.Request) {
+		seen = append(seen, req.RequestLine.RequestTarget)
+		echoTargetHandler(w, req)
+	}})
+	client, done := startConnection(t, s)
+	go client.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 35\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"0\r\n\r\nGET /smuggled HTTP/1.1\r\nX: X"))
+	br := bufio.NewReader(client)
+	res, _ := readResponse(t, br)
+	assert.Equal(t, 400, res.StatusCode)
+	assert.True(t, res.Close)
+	_, err := br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	<-done
+	assert.Empty(t, seen)
+}
+
+// startServer serves config on a free localhost port
+func startServer(t *testing.T, config Config) *Server {
+	t.Helper()
+	config.Addr = "127.0.0.1:0"
+	s := New(config)
+	go s.ListenAndServe()
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, time.Millisecond)
+
+	return s
+}
+
+// assertNoLeaks waits for the goroutine count to drop back to baseline
+func assertNoLeaks(t *testing.T, baseline int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), baseline)
+}
+
+func TestClose(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	s := startServer(t, Config{Handler: echoTargetHandler})
+	addr := s.Addr().String()
+
+	// Test: Close stops the listener and drops open connections
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	_, err = client.Write([]byte("GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(client)
+	readResponse(t, br)
+
+	require.NoError(t, s.Close())
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	client.Close()
+
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+	assertNoLeaks(t, baseline)
+}
+
+func TestShutdown(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, Config{Handler: func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/slow" {
+			close(started)
+			<-release
+		}
+		echoTargetHandler(w, req)
+	}})
+	addr := s.Addr().String()
+
+	// Test: In-flight requests finish, idle connections are closed
+	idle, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer idle.Close()
+	_, err = idle.Write([]byte("GET /idle HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	idleReader := bufio.NewReader(idle)
+	readResponse(t, idleReader)
+
+	busy, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer busy.Close()
+	_, err = busy.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	shutdownErr := make(chan error)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+
+	_, err = idleReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	select {
+	case <-shutdownErr:
+		t.Fatal("shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	_, err = net.Dial("tcp", addr)
+	assert.Error(t, err)
+
+	close(release)
+	busyReader := bufio.NewReader(busy)
+	_, body := readResponse(t, busyReader)
+	assert.Equal(t, "/slow", body)
+	_, err = busyReader.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	require.NoError(t, <-shutdownErr)
+	assertNoLeaks(t, baseline)
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s := startServer(t, Config{Handler: func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		echoTargetHandler(w, req)
+	}})
+
+	// Test: Connections still busy when ctx expires are force-closed
+	client, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET /stuck HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+	_, err = client.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	close(release)
+	assertNoLeaks(t, baseline)
+}
+
+func TestMiddleware(t *testing.T) {
+	// Test: Chain runs middleware outermost first
+	order := []string{}
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(w *response.Writer, req *request.Request) {
+				order = append(order, name+" before")
+				next(w, req)
+				order = append(order, name+" after")
+			}
+		}
+	}
+	h := Chain(trace("a"), trace("b"), trace("c"))(echoTargetHandler)
+
+	// Test: Middleware observes the status code and body size
+	var status response.StatusCode
+	var written int
+	observe := func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			next(w, req)
+			status = w.StatusCode()
+			written = w.BytesWritten()
+		}
+	}
+	s := New(Config{Handler: h, Middleware: []Middleware{observe}, IdleTimeout: time.Second})
+	client, done := startConnection(t, s)
+	go client.Write([]byte("GET /observed HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	_, body := readResponse(t, bufio.NewReader(client))
+	<-done
+
+	assert.Equal(t, "/observed", body)
+	assert.Equal(t, []string{"a before", "b before", "c before", "c after", "b after", "a after"}, order)
+	assert.Equal(t, response.StatusOK, status)
+	assert.Equal(t, len("/observed"), written)
+
+	// Test: Chain without middleware leaves the handler alone
+	s = New(Config{Handler: echoTargetHandler, IdleTimeout: time.Second})
+	client, done = startConnection(t, s)
+	go client.Write([]byte("GET /plain HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	_, body = readResponse(t, bufio.NewReader(client))
+	<-done
+	assert.Equal(t, "/plain", body)
+}
+
+func TestPanicRecovery(t *testing.T) {
+	logs := &bytes.Buffer{}
+	s := startServer(t, Config{Logger: log.New(logs, "", 0), Handler: func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/early":
+			panic("boom before writing")
+		case "/late":
+			w.WriteStatusLine(response.StatusOK)
+			w.WriteHeaders(*response.GetDefaultHeaders(100))
+			w.WriteBody([]byte("partial"))
+			panic("boom after writing")
+		}
+		echoTargetHandler(w, req)
+	}})
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// Test: Panic before anything was written becomes a 500
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET /early HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	br := bufio.NewReader(client)
+	res, _ := readResponse(t, br)
+	assert.Equal(t, 500, res.StatusCode)
+	assert.True(t, res.Close)
+	_, err = br.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Contains(t, logs.String(), "boom before writing")
+	assert.Contains(t, logs.String(), "goroutine")
+
+	// Test: Panic mid-response just closes the connection
+	client, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET /late HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	raw, err := io.ReadAll(client)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(raw), "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(string(raw), "partial"))
+	assert.NotContains(t, string(raw), "500")
+
+	// Test: The server keeps serving other connections
+	client, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("GET /fine HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, body := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/fine", body)
+}
+
+func TestHandlerErrors(t *testing.T) {
+	failing := func(w *response.Writer, req *request.Request) *HandlerError {
+		if req.RequestLine.RequestTarget == "/ok" {
+			echoTargetHandler(w, req)
+			return nil
+		}
+		return &HandlerError{StatusCode: response.StatusNotFound, Message: "no <such> thing"}
+	}
+	serve := func(h Handler, target string) (*http.Response, string) {
+		req, err := request.RequestFromReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		buf := &bytes.Buffer{}
+		h(response.NewWriter(buf), req)
+		return readResponse(t, bufio.NewReader(buf))
+	}
+
+	// Test: Successful handlers are left alone
+	res, body := serve(WithErrors(failing, nil), "/ok")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/ok", body)
+
+	// Test: Plain text is the default
+	res, body = serve(WithErrors(failing, nil), "/missing")
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "text/plain", res.Header.Get("Content-Type"))
+	assert.Equal(t, "no <such> thing\n", body)
+
+	// Test: HTML escapes the message
+	res, body = serve(WithErrors(failing, HTMLErrorRenderer), "/missing")
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+	assert.Contains(t, body, "<title>404 Not Found</title>")
+	assert.Contains(t, body, "no &lt;such&gt; thing")
+
+	// Test: Problem details
+	res, body = serve(WithErrors(failing, ProblemErrorRenderer), "/missing")
+	assert.Equal(t, 404, res.StatusCode)
+	assert.Equal(t, "application/problem+json", res.Header.Get("Content-Type"))
+	var p map[string]any
+	require.NoError(t, json.Unmarshal([]byte(body), &p))
+	assert.Equal(t, map[string]any{
+		"type":     "about:blank",
+		"title":    "Not Found",
+		"status":   float64(404),
+		"detail":   "no <such> thing",
+		"instance": "/missing",
+	}, p)
+
+	// Test: Errors after the response started are dropped
+	late := func(w *response.Writer, req *request.Request) *HandlerError {
+		echoTargetHandler(w, req)
+		return &HandlerError{StatusCode: response.StatusInternalServerError, Message: "too late"}
+	}
+	res, body = serve(WithErrors(late, nil), "/late")
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/late", body)
+}
+
+func TestTimeouts(t *testing.T) {
+	// Test: Slow headers get a 408
+	s := New(Config{
+		Handler:           echoTargetHandler,
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		IdleTimeout:       time.Second,
+	})
+	client, done := startConnection(t, s)
+	_, err := client.Write([]byte("GET /slow HTTP/1.1\r\nHost: loc"))
+	require.NoError(t, err)
+	res, _ := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 408, res.StatusCode)
+	assert.True(t, res.Close)
+	<-done
+
+	// Test: Idle time doesn't count against the header timeout
+	client, done = startConnection(t, s)
+	time.Sleep(100 * time.Millisecond)
+	go client.Write([]byte("GET /late HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	res, body := readResponse(t, bufio.NewReader(client))
+	assert.Equal(t, 200, res.StatusCode)
+	assert.Equal(t, "/late", body)
+	<-done
+
+	// Test: Slow bodies fail the handler's read
+	bodyErr := make(chan error, 1)
+	s = New(Config{Handler: func(w *response.Writer, req *request.Request) {
+		_, err := req.ReadBody()
+		bodyErr <- err
+	}, ReadTimeout: 50 * time.Millisecond})
+	client, done = startConnection(t, s)
+	_, err = client.Write([]byte("POST /upload HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	require.NoError(t, err)
+	var netErr net.Error
+	// The handler wrote nothing, so the server still answers with a 200
+	go io.Copy(io.Discard, client)
+	require.ErrorAs(t, <-bodyErr, &netErr)
+	assert.True(t, netErr.Timeout())
+	<-done
+
+	// Test: Clients that don't read the response time out the write
+	writeErr := make(chan error, 1)
+	s = New(Config{Handler: func(w *response.Writer, req *request.Request) {
+		body := make([]byte, 1024)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		_, err := w.WriteBody(body)
+		writeErr <- err
+	}, WriteTimeout: 50 * time.Millisecond})
+	client, done = startConnection(t, s)
+	_, err = client.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	require.ErrorAs(t, <-writeErr, &netErr)
+	assert.True(t, netErr.Timeout())
+	<-done
+}
+
+func TestListenAndServe(t *testing.T) {
+	// Test: Port 0 reports the real address
+	s := New(Config{Addr: "127.0.0.1:0", Handler: echoTargetHandler})
+	assert.Nil(t, s.Addr())
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, time.Millisecond)
+	addr := s.Addr().(*net.TCPAddr)
+	assert.True(t, addr.IP.IsLoopback())
+	assert.NotZero(t, addr.Port)
+
+	res, err := http.Get("http://" + addr.String() + "/hello")
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "/hello", string(body))
+
+	// Test: Closing makes Serve return ErrServerClosed
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+	assert.ErrorIs(t, s.Serve(nopListener{}), ErrServerClosed)
+
+	// Test: Bad address
+	s = New(Config{Addr: "127.0.0.1:-1", Handler: echoTargetHandler})
+	assert.Error(t, s.ListenAndServe())
+}
+
+// nopListener is a listener that must never be used
+type nopListener struct{ net.Listener }
+
+func (nopListener) Close() error { return nil }
+
+func TestMaxConns(t *testing.T) {
+	// Test: Connections over the cap wait for a free slot
+	release := make(chan struct{})
+	s := startServer(t, Config{MaxConns: 1, Handler: func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/hold" {
+			<-release
+		}
+		echoTargetHandler(w, req)
+	}})
+	defer s.Close()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("GET /hold HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	second, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte("GET /queued HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+
+	answered := make(chan string)
+	go func() {
+		res, err := http.ReadResponse(bufio.NewReader(second), nil)
+		if err != nil {
+			answered <- err.Error()
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		answered <- string(body)
+	}()
+	select {
+	case <-answered:
+		t.Fatal("second connection was served while the first held the only slot")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	_, body := readResponse(t, bufio.NewReader(first))
+	assert.Equal(t, "/hold", body)
+	assert.Equal(t, "/queued", <-answered)
+}
+
+// remoteAddrHandler answers with the network and address of the client
+func remoteAddrHandler(w *response.Writer, req *request.Request) {
+	body := []byte(req.RemoteAddr.Network() + " " + req.RemoteAddr.String())
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// getTarget sends a GET request for target over a new connection to addr
+// and returns the response body
+func getTarget(t *testing.T, addr net.Addr, target string) string {
+	t.Helper()
+	conn, err := net.Dial(addr.Network(), addr.String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET " + target + " HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, res.StatusCode)
+	return body
+}
+
+func TestUnixSocket(t *testing.T) {
+	// Test: ListenAndServe on a Unix socket path
+	path := filepath.Join(t.TempDir(), "server.sock")
+	s := New(Config{Network: "unix", Addr: path, Handler: remoteAddrHandler})
+	served := make(chan error, 1)
+	go func() { served <- s.ListenAndServe() }()
+	require.Eventually(t, func() bool { return s.Addr() != nil }, time.Second, time.Millisecond)
+
+	assert.Equal(t, path, s.Addr().String())
+	assert.True(t, strings.HasPrefix(getTarget(t, s.Addr(), "/"), "unix "))
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+
+	// Test: One server serving TCP and abstract Unix listeners together
+	if runtime.GOOS != "linux" {
+		t.Skip("abstract sockets are Linux only")
+	}
+	s = New(Config{Handler: remoteAddrHandler})
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	abstract, err := net.Listen("unix", "@httpfromtcp-test-"+strconv.Itoa(os.Getpid()))
+	require.NoError(t, err)
+	go s.Serve(tcp)
+	go func() { served <- s.Serve(abstract) }()
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.listeners) == 2
+	}, time.Second, time.Millisecond)
+
+	assert.True(t, strings.HasPrefix(getTarget(t, tcp.Addr(), "/"), "tcp 127.0.0.1:"))
+	assert.True(t, strings.HasPrefix(getTarget(t, abstract.Addr(), "/"), "unix "))
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+}
+
+func TestRejectOverLimit(t *testing.T) {
+	// Test: Connections over the cap are turned away with 503
+	release := make(chan struct{})
+	s := startServer(t, Config{MaxConns: 1, RejectOverLimit: true, Handler: func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/hold" {
+			<-release
+		}
+		echoTargetHandler(w, req)
+	}})
+	defer s.Close()
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer first.Close()
+	_, err = first.Write([]byte("GET /hold HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 1
+	}, time.Second, time.Millisecond)
+
+	second, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	_, err = second.Write([]byte("GET /rejected HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	res, _ := readResponse(t, bufio.NewReader(second))
+	assert.Equal(t, 503, res.StatusCode)
+	assert.True(t, res.Close)
+
+	// Test: Once too many are being rejected the rest are closed unanswered
+	require.Eventually(t, func() bool { return len(s.rejecting) == 0 }, 2*time.Second, time.Millisecond)
+	for range maxRejecting {
+		s.rejecting <- struct{}{}
+	}
+	third, err := net.Dial("tcp", s.Addr().String())
+	require.NoError(t, err)
+	defer third.Close()
+	third.SetReadDeadline(time.Now().Add(time.Second))
+	raw, err := io.ReadAll(third)
+	assert.NoError(t, err)
+	assert.Empty(t, raw)
+	for range maxRejecting {
+		<-s.rejecting
+	}
+
+	// Test: The slot is available again once the first connection is done
+	close(release)
+	_, body := readResponse(t, bufio.NewReader(first))
+	assert.Equal(t, "/hold", body)
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.conns) == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "/after", getTarget(t, s.Addr(), "/after"))
+}
+
+// flakyListener fails its first Accept calls with a temporary error
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestAcceptRetry(t *testing.T) {
+	// Test: Temporary accept errors are retried instead of stopping the server
+	logs := &bytes.Buffer{}
+	s := New(Config{Handler: echoTargetHandler, Logger: log.New(logs, "", 0)})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(&flakyListener{Listener: listener, failures: 3}) }()
+
+	assert.Equal(t, "/retried", getTarget(t, listener.Addr(), "/retried"))
+	require.NoError(t, s.Close())
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+	assert.Equal(t, 3, strings.Count(logs.String(), "accept error: too many open files"))
+
+	// Test: Other errors stop the server
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener.Close()
+	assert.Error(t, New(Config{Handler: echoTargetHandler}).Serve(listener))
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// certCheckInterval is how often CertReloader looks for changed files by
+// default
+const certCheckInterval = 5 * time.Second
+
+// ServeTLS serves HTTPS on listener, see Serve. The certificate is loaded
+// from certFile and keyFile and reloaded whenever they change on disk. With
+// both empty the certificates must come from Config.TLSConfig.
+func (s *Server) ServeTLS(listener net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if s.config.TLSConfig != nil {
+		config = s.config.TLSConfig.Clone()
+	}
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{"http/1.1"}
+	}
+
+	if certFile != "" || keyFile != "" {
+		certs := &CertReloader{Logger: s.logger}
+		if err := certs.Add(certFile, keyFile); err != nil {
+			listener.Close()
+			return err
+		}
+		config.GetCertificate = certs.GetCertificate
+	}
+	if len(config.Certificates) == 0 && config.GetCertificate == nil && config.GetConfigForClient == nil {
+		listener.Close()
+		return fmt.Errorf("no TLS certificate configured")
+	}
+
+	return s.Serve(tls.NewListener(listener, config))
+}
+
+// ListenAndServeTLS listens on the configured network and address, ":https"
+// if empty, and serves HTTPS on it, see ServeTLS.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	listener, err := s.listen(":https")
+	if err != nil {
+		return err
+	}
+
+	return s.ServeTLS(listener, certFile, keyFile)
+}
+
+// CertReloader serves certificates from files on disk, picking the one that
+// matches the server name the client asked for. Each pair is reloaded when
+// one of its files changes, so renewed certificates are picked up without a
+// restart. Use its GetCertificate in a tls.Config.
+type CertReloader struct {
+	// Interval is how often the files are checked for changes, every 5
+	// seconds if zero. Checks run in the background, handshakes don't wait
+	// for them.
+	Interval time.Duration
+	// Logger receives failed reloads, the standard logger if nil
+	Logger *log.Logger
+
+	mu    sync.Mutex
+	pairs []*certPair
+	// checked is when the last check for changed files started
+	checked  time.Time
+	checking bool
+}
+
+type certPair struct {
+	certFile, keyFile string
+	cert              *tls.Certificate
+	// modified holds the modification times the certificate was loaded at
+	modified [2]time.Time
+}
+
+// Add loads a certificate and key pair. Pairs added first are preferred
+// when several match, the first one is also used for clients without SNI.
+func (c *CertReloader) Add(certFile, keyFile string) error {
+	pair := &certPair{certFile: certFile, keyFile: keyFile}
+	cert, modified, err := pair.load()
+	if err != nil {
+		return err
+	}
+	pair.cert, pair.modified = cert, modified
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pairs = append(c.pairs, pair)
+
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+func (c *CertReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pairs) == 0 {
+		return nil, fmt.Errorf("no TLS certificate configured")
+	}
+	interval := c.Interval
+	if interval <= 0 {
+		interval = certCheckInterval
+	}
+	if !c.checking && time.Since(c.checked) >= interval {
+		c.checking = true
+		c.checked = time.Now()
+		go c.reload()
+	}
+	for _, pair := range c.pairs {
+		if hello.SupportsCertificate(pair.cert) == nil {
+			return pair.cert, nil
+		}
+	}
+
+	return c.pairs[0].cert, nil
+}
+
+// reload loads the pairs whose files changed. The files are read without
+// holding the lock so handshakes go on meanwhile.
+func (c *CertReloader) reload() {
+	c.mu.Lock()
+	pairs := slices.Clone(c.pairs)
+	c.mu.Unlock()
+
+	for _, pair := range pairs {
+		cert, modified, err := pair.load()
+		if err != nil {
+			// A failed reload, say of a half written file, keeps serving the
+			// previous certificate
+			c.logger().Printf("reloading certificate %s: %v", pair.certFile, err)
+			continue
+		}
+		if cert != nil {
+			c.mu.Lock()
+			pair.cert, pair.modified = cert, modified
+			c.mu.Unlock()
+		}
+	}
+
+	c.mu.Lock()
+	c.checking = false
+	c.mu.Unlock()
+}
+
+func (c *CertReloader) logger() *log.Logger {
+	if c.Logger == nil {
+		return log.Default()
+	}
+	return c.Logger
+}
+
+// load loads the pair if either file changed since the last load. It
+// returns a nil certificate if nothing changed.
+func (p *certPair) load() (*tls.Certificate, [2]time.Time, error) {
+	var modified [2]time.Time
+	for i, name := range []string{p.certFile, p.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, modified, err
+		}
+		modified[i] = info.ModTime()
+	}
+	if p.cert != nil && modified == p.modified {
+		return nil, modified, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(p.certFile, p.keyFile)
+	if err != nil {
+		return nil, modified, err
+	}
+
+	return &cert, modified, nil
+}
+
+// SelfSignedCert generates a PEM encoded certificate and key valid for a day
+// for hosts, which are DNS names or IP addresses. It is meant for tests and
+// local development, clients have to trust the certificate explicitly.
+func SelfSignedCert(hosts ...string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"httpfromtcp"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	if len(hosts) > 0 {
+		template.Subject.CommonName = hosts[0]
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+)
+
+// writeCert writes a self-signed certificate for hosts to dir and adds it
+// to pool
+func writeCert(t *testing.T, dir string, pool *x509.CertPool, hosts ...string) (string, string) {
+	t.Helper()
+	certPEM, keyPEM, err := SelfSignedCert(hosts...)
+	require.NoError(t, err)
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+
+	certFile := filepath.Join(dir, hosts[0]+".crt")
+	keyFile := filepath.Join(dir, hosts[0]+".key")
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+
+	return certFile, keyFile
+}
+
+// serverNameHandler answers with the server name the client asked for
+func serverNameHandler(w *response.Writer, req *request.Request) {
+	body := []byte("plain")
+	if req.TLS != nil {
+		body = []byte(req.TLS.ServerName)
+	}
+	w.WriteStatusLine(response.StatusOK)
+	w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+// getTLS sends a GET request to addr for serverName and returns the response
+// body along with the DNS names of the certificate the server presented
+func getTLS(t *testing.T, addr net.Addr, pool *x509.CertPool, serverName string) (string, []string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr.String(), &tls.Config{RootCAs: pool, ServerName: serverName})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + serverName + "\r\nConnection: close\r\n\r\n"))
+	require.NoError(t, err)
+	res, body := readResponse(t, bufio.NewReader(conn))
+	assert.Equal(t, 200, res.StatusCode)
+
+	return body, conn.ConnectionState().PeerCertificates[0].DNSNames
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	pool := x509.NewCertPool()
+	certFile, keyFile := writeCert(t, dir, pool, "localhost")
+
+	// Test: Certificate from files
+	s := New(Config{Handler: serverNameHandler, Logger: log.New(io.Discard, "", 0)})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- s.ServeTLS(listener, certFile, keyFile) }()
+
+	body, names := getTLS(t, listener.Addr(), pool, "localhost")
+	assert.Equal(t, "localhost", body)
+	assert.Equal(t, []string{"localhost"}, names)
+
+	// Test: Plain HTTP is refused by the handshake
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	reply, _ := bufio.NewReader(conn).ReadString('\n')
+	assert.NotContains(t, reply, "HTTP/1.1")
+	conn.Close()
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	assert.ErrorIs(t, <-served, ErrServerClosed)
+
+	// Test: Certificates from the TLSConfig
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	require.NoError(t, err)
+	s = New(Config{Handler: serverNameHandler, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}})
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.ServeTLS(listener, "", "")
+	body, _ = getTLS(t, listener.Addr(), pool, "localhost")
+	assert.Equal(t, "localhost", body)
+	s.Close()
+
+	// Test: No certificate at all
+	s = New(Config{Handler: serverNameHandler})
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Error(t, s.ServeTLS(listener, "", ""))
+	assert.Error(t, s.ServeTLS(listener, filepath.Join(dir, "missing.crt"), keyFile))
+}
+
+// lockedBuffer collects what the background reloads log
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	pool := x509.NewCertPool()
+	logs := &lockedBuffer{}
+	certs := &CertReloader{Interval: 10 * time.Millisecond, Logger: log.New(logs, "", 0)}
+	require.NoError(t, certs.Add(writeCert(t, dir, pool, "a.test")))
+	require.NoError(t, certs.Add(writeCert(t, dir, pool, "b.test", "*.b.test")))
+	assert.Error(t, certs.Add(filepath.Join(dir, "missing.crt"), filepath.Join(dir, "missing.key")))
+
+	s := New(Config{Handler: serverNameHandler})
+	defer s.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.Serve(tls.NewListener(listener, &tls.Config{GetCertificate: certs.GetCertificate}))
+
+	// Test: The certificate is picked by SNI
+	body, names := getTLS(t, listener.Addr(), pool, "a.test")
+	assert.Equal(t, "a.test", body)
+	assert.Equal(t, []string{"a.test"}, names)
+	body, names = getTLS(t, listener.Addr(), pool, "www.b.test")
+	assert.Equal(t, "www.b.test", body)
+	assert.Equal(t, []string{"b.test", "*.b.test"}, names)
+
+	// Test: A changed certificate is served without a restart
+	certPEM, keyPEM, err := SelfSignedCert("a.test", "new.a.test")
+	require.NoError(t, err)
+	require.True(t, pool.AppendCertsFromPEM(certPEM))
+	later := time.Now().Add(time.Minute)
+	for name, data := range map[string][]byte{"a.test.crt": certPEM, "a.test.key": keyPEM} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o600))
+		require.NoError(t, os.Chtimes(filepath.Join(dir, name), later, later))
+	}
+	// The check runs in the background, so the handshake that starts it may
+	// still get the old certificate
+	require.Eventually(t, func() bool {
+		_, names = getTLS(t, listener.Addr(), pool, "a.test")
+		return len(names) == 2
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"a.test", "new.a.test"}, names)
+
+	// Test: A broken file keeps the previous certificate and is logged
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.test.crt"), []byte("garbage"), 0o600))
+	require.Eventually(t, func() bool {
+		_, names = getTLS(t, listener.Addr(), pool, "a.test")
+		return strings.Contains(logs.String(), "a.test.crt")
+	}, 2*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"a.test", "new.a.test"}, names)
+}
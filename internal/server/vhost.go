@@ -0,0 +1,115 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+)
+
+// VirtualHosts dispatches requests to a handler chosen by the Host header.
+// Hosts are registered as an exact name such as "example.com", a wildcard
+// such as "*.example.com" matching any subdomain, or "*" for requests no
+// other host matches.
+type VirtualHosts struct {
+	hosts map[string]Handler
+	// wildcards maps the suffix of each wildcard host, e.g. ".example.com"
+	wildcards map[string]Handler
+	fallback  Handler
+
+	// ErrorRenderer writes the 421 for unknown hosts. TextErrorRenderer if
+	// nil.
+	ErrorRenderer ErrorRenderer
+}
+
+func NewVirtualHosts() *VirtualHosts {
+	return &VirtualHosts{
+		hosts:     map[string]Handler{},
+		wildcards: map[string]Handler{},
+	}
+}
+
+// Handle mounts handler for host. It panics if host is malformed or already
+// registered.
+func (v *VirtualHosts) Handle(host string, handler Handler) {
+	host = strings.ToLower(host)
+	switch {
+	case host == "*":
+		if v.fallback != nil {
+			panic("server: default host registered twice")
+		}
+		v.fallback = handler
+	case strings.HasPrefix(host, "*."):
+		suffix := host[1:]
+		if strings.Contains(suffix[1:], "*") || suffix == "." {
+			panic(fmt.Sprintf("server: invalid host %q", host))
+		}
+		if _, ok := v.wildcards[suffix]; ok {
+			panic(fmt.Sprintf("server: host %q registered twice", host))
+		}
+		v.wildcards[suffix] = handler
+	default:
+		host = hostname(host)
+		if host == "" || strings.Contains(host, "*") {
+			panic(fmt.Sprintf("server: invalid host %q", host))
+		}
+		if _, ok := v.hosts[host]; ok {
+			panic(fmt.Sprintf("server: host %q registered twice", host))
+		}
+		v.hosts[host] = handler
+	}
+}
+
+// Handler returns a Handler serving the registered hosts. An exact host wins
+// over wildcards, and the wildcard with the longest suffix wins over shorter
+// ones. Requests for unknown hosts without a default get 421 Misdirected
+// Request.
+func (v *VirtualHosts) Handler() Handler {
+	return func(w *response.Writer, req *request.Request) {
+		if handler := v.match(hostname(req.Host())); handler != nil {
+			handler(w, req)
+			return
+		}
+
+		render := v.ErrorRenderer
+		if render == nil {
+			render = TextErrorRenderer
+		}
+		render(w, req, &HandlerError{
+			StatusCode: response.StatusMisdirectedRequest,
+			Message:    response.StatusText(response.StatusMisdirectedRequest),
+		})
+	}
+}
+
+func (v *VirtualHosts) match(host string) Handler {
+	if handler, ok := v.hosts[host]; ok {
+		return handler
+	}
+
+	// Try ever shorter suffixes: a.b.example.com, then b.example.com, ...
+	for i := strings.IndexByte(host, '.'); i != -1; {
+		if handler, ok := v.wildcards[host[i:]]; ok {
+			return handler
+		}
+		next := strings.IndexByte(host[i+1:], '.')
+		if next == -1 {
+			break
+		}
+		i += next + 1
+	}
+
+	return v.fallback
+}
+
+// hostname reduces a Host header value to the lowercased name, without the
+// port and the trailing dot of a fully qualified name
+func hostname(host string) string {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return strings.ToLower(host)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/trial-pyth/httpfromtcp/internal/request"
+	"github.com/trial-pyth/httpfromtcp/internal/response"
+)
+
+// site responds with its own name
+func site(name string) Handler {
+	return func(w *response.Writer, req *request.Request) {
+		body := []byte(name)
+		w.WriteStatusLine(response.StatusOK)
+		w.WriteHeaders(*response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+}
+
+func serveHost(t *testing.T, h Handler, host string) (*http.Response, string) {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	h(response.NewWriter(buf), req)
+
+	return readResponse(t, bufio.NewReader(buf))
+}
+
+func TestVirtualHosts(t *testing.T) {
+	v := NewVirtualHosts()
+	v.Handle("example.com", site("apex"))
+	v.Handle("api.example.com", site("api"))
+	v.Handle("*.example.com", site("any"))
+	v.Handle("*.eu.example.com", site("eu"))
+	v.Handle("[::1]", site("ipv6"))
+	h := v.Handler()
+
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "apex"},
+		{"EXAMPLE.com:8080", "apex"},
+		{"example.com.", "apex"},
+		{"api.example.com", "api"},
+		{"www.example.com", "any"},
+		{"a.b.example.com", "any"},
+		{"eu.example.com", "any"},
+		{"shop.eu.example.com", "eu"},
+		{"[::1]:42069", "ipv6"},
+	}
+	for _, c := range cases {
+		res, body := serveHost(t, h, c.host)
+		assert.Equal(t, 200, res.StatusCode, c.host)
+		assert.Equal(t, c.want, body, c.host)
+	}
+
+	// Test: Unknown hosts without a default
+	for _, host := range []string{"example.org", "notexample.com", ""} {
+		res, _ := serveHost(t, h, host)
+		assert.Equal(t, 421, res.StatusCode, host)
+	}
+
+	// Test: The 421 goes through the configured renderer
+	v.ErrorRenderer = HTMLErrorRenderer
+	res, body := serveHost(t, h, "example.org")
+	assert.Equal(t, 421, res.StatusCode)
+	assert.Equal(t, "text/html", res.Header.Get("Content-Type"))
+	assert.Contains(t, body, "<h1>421 Misdirected Request</h1>")
+
+	// Test: Unknown hosts with a default
+	v.Handle("*", site("default"))
+	_, body = serveHost(t, h, "example.org")
+	assert.Equal(t, "default", body)
+}
+
+func TestInvalidVirtualHosts(t *testing.T) {
+	for _, host := range []string{"", "*.", "a.*.example.com", "*example.com"} {
+		assert.Panics(t, func() { NewVirtualHosts().Handle(host, site("x")) }, host)
+	}
+
+	v := NewVirtualHosts()
+	v.Handle("example.com", site("a"))
+	v.Handle("*.example.com", site("a"))
+	v.Handle("*", site("a"))
+	assert.Panics(t, func() { v.Handle("Example.com", site("b")) })
+	assert.Panics(t, func() { v.Handle("*.example.com", site("b")) })
+	assert.Panics(t, func() { v.Handle("*", site("b")) })
+}